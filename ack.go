@@ -1,13 +1,14 @@
 package dhcp4
 
-import "encoding/binary"
-
 // Ack is a server to client packet with configuration parameters,
 // including committed network address.
 type Ack struct {
 	Packet
 
 	msg *Packet
+
+	// Whether the client identifier is echoed (RFC6842)
+	echoClientID bool
 }
 
 func CreateAck(msg *Packet) Ack {
@@ -16,11 +17,17 @@ func CreateAck(msg *Packet) Ack {
 		msg:    msg,
 	}
 
+	rep.echoClientID = copyClientID(&rep.Packet, msg)
 	rep.SetMessageType(MessageTypeAck)
 	return rep
 }
 
 // From RFC2131, table 3:
+//   Field      DHCPACK
+//   -----      -------
+//   'yiaddr'   IP address assigned to client (DHCPREQUEST)
+//              0 (DHCPINFORM)
+//
 //   Option                    DHCPACK
 //   ------                    -------
 //   Requested IP address      MUST NOT
@@ -30,55 +37,54 @@ func CreateAck(msg *Packet) Ack {
 //   DHCP message type         DHCPACK
 //   Parameter request list    MUST NOT
 //   Message                   SHOULD
-//   Client identifier         MUST NOT
+//   Client identifier         MUST NOT (MUST echo, per RFC6842)
 //   Vendor class identifier   MAY
 //   Server identifier         MUST
 //   Maximum message size      MUST NOT
 //   All others                MAY
 
 var dhcpAckOnRequestValidation = []Validation{
+	ValidateFieldSet(FieldYIAddr),
 	ValidateMust(OptionAddressTime),
 }
 
 var dhcpAckOnInformValidation = []Validation{
+	ValidateFieldZero(FieldYIAddr),
 	ValidateMustNot(OptionAddressTime),
 }
 
 var dhcpAckValidation = []Validation{
 	ValidateMustNot(OptionAddressRequest),
 	ValidateMustNot(OptionParameterList),
-	ValidateMustNot(OptionClientID),
 	ValidateMust(OptionDHCPServerID),
 	ValidateMustNot(OptionDHCPMaxMsgSize),
+	ValidateLeaseTimes(),
+	ValidateSubnet(),
 }
 
 func (d *Ack) Validate() error {
-	var err error
+	vs := []Validation{clientIDValidation(d.msg, d.echoClientID)}
 
 	// Validation is subtly different based on type of request
 	switch d.msg.GetMessageType() {
 	case MessageTypeRequest:
-		err = Validate(d.Packet, dhcpAckOnRequestValidation)
+		vs = append(vs, dhcpAckOnRequestValidation...)
 	case MessageTypeInform:
-		err = Validate(d.Packet, dhcpAckOnInformValidation)
-	}
-
-	if err != nil {
-		return err
+		vs = append(vs, dhcpAckOnInformValidation...)
 	}
 
-	return Validate(d.Packet, dhcpAckValidation)
+	return Validate(d.Packet, append(vs, dhcpAckValidation...))
 }
 
 func (d *Ack) ToBytes() ([]byte, error) {
-	opts := packetToBytesOptions{}
-
-	// Copy MaxMsgSize if set in the request
-	if v, ok := d.Message().GetOption(OptionDHCPMaxMsgSize); ok {
-		opts.maxLen = binary.BigEndian.Uint16(v)
-	}
+	return d.AppendTo(nil)
+}
 
-	return PacketToBytes(d.Packet, &opts)
+// AppendTo appends the wire-level representation of the reply to dst and
+// returns the extended buffer.
+func (d *Ack) AppendTo(dst []byte) ([]byte, error) {
+	opts := replyOptions(d.Message())
+	return appendPacket(dst, d.Packet, &opts)
 }
 
 func (d *Ack) Message() *Packet {
@@ -1,16 +1,23 @@
 package dhcp4
 
-import "testing"
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
 
 func TestAckOnRequestValidation(t *testing.T) {
 	testCase := replyValidationTestCase{
 		newReply: func() ValidatingReply {
 			msg := NewPacket(BootRequest)
 			msg.SetMessageType(MessageTypeRequest)
-			return &Ack{
+			rep := Ack{
 				Packet: NewPacket(BootReply),
 				msg:    &msg,
 			}
+			rep.SetYIAddr(net.IP{10, 0, 0, 10})
+			return &rep
 		},
 		must: []Option{
 			OptionAddressTime,
@@ -51,3 +58,33 @@ func TestAckOnInformValidation(t *testing.T) {
 
 	testCase.Test(t)
 }
+
+func TestAckClientIDEcho(t *testing.T) {
+	msg := NewPacket(BootRequest)
+	msg.SetMessageType(MessageTypeRequest)
+	msg.SetOption(OptionClientID, []byte("\x00foo"))
+
+	rep := CreateAck(&msg)
+	rep.SetYIAddr(net.IP{10, 0, 0, 10})
+	rep.SetOption(OptionAddressTime, []byte("foo"))
+	rep.SetOption(OptionDHCPServerID, []byte("foo"))
+	assert.NoError(t, rep.Validate())
+
+	// Removing the client identifier fails validation
+	delete(rep.OptionMap, OptionClientID)
+	assert.Error(t, rep.Validate())
+}
+
+func TestAckOnInformFieldValidation(t *testing.T) {
+	msg := NewPacket(BootRequest)
+	msg.SetMessageType(MessageTypeInform)
+
+	rep := CreateAck(&msg)
+	rep.SetOption(OptionDHCPServerID, []byte("foo"))
+	assert.NoError(t, rep.Validate())
+
+	rep.SetYIAddr(net.IP{10, 0, 0, 10})
+	assert.Equal(t, ValidationErrors{
+		&FieldValidationError{Field: FieldYIAddr, MustBeSet: false},
+	}, rep.Validate())
+}
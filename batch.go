@@ -0,0 +1,314 @@
+package dhcp4
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	"golang.org/x/net/ipv4"
+)
+
+// Size of the buffers used to read batches of packets. It fits a packet in a
+// jumbo frame; larger packets are truncated and fail to parse.
+const batchBufferSize = 9216
+
+// BatchPacket is a packet read or written by a BatchConn.
+type BatchPacket struct {
+	// Buf is the buffer to read into, or the payload to write.
+	Buf []byte
+
+	// N is the number of bytes read.
+	N int
+
+	// Addr is the source address of a packet read, or the destination
+	// address of a packet to write.
+	Addr net.Addr
+
+	// IfIndex is the index of the interface the packet arrived on, or should
+	// be sent on.
+	IfIndex int
+
+	// Dst is the local address a packet read was sent to, if known.
+	Dst net.IP
+
+	// Src is the source address of a packet to write. If nil, the kernel
+	// picks it.
+	Src net.IP
+}
+
+// BatchConn is implemented by PacketConns that can read and write several
+// packets with a single system call, such as the ones returned by
+// NewPacketConn (using recvmmsg and sendmmsg on Linux).
+type BatchConn interface {
+	// ReadBatch reads up to len(ps) packets, and returns the number of
+	// packets read. It blocks until at least one packet is read.
+	ReadBatch(ps []BatchPacket) (int, error)
+
+	// WriteBatch writes the packets, and returns the number of packets
+	// written.
+	WriteBatch(ps []BatchPacket) (int, error)
+}
+
+// batchMessages holds the messages of the batch system calls of a
+// connection, which are reused from one call to the next.
+type batchMessages struct {
+	mu sync.Mutex
+	ms []ipv4.Message
+
+	// Control messages the OOB data of every message was marshaled from,
+	// when writing
+	cms []ipv4.ControlMessage
+}
+
+// get returns n messages, with a single buffer each.
+func (b *batchMessages) get(n int) []ipv4.Message {
+	for len(b.ms) < n {
+		b.ms = append(b.ms, ipv4.Message{Buffers: make([][]byte, 1)})
+		b.cms = append(b.cms, ipv4.ControlMessage{IfIndex: -1})
+	}
+	return b.ms[:n]
+}
+
+// ReadBatch reads up to len(ps) packets from the connection.
+func (p *packetConn) ReadBatch(ps []BatchPacket) (int, error) {
+	p.readBatch.mu.Lock()
+	defer p.readBatch.mu.Unlock()
+
+	ms := p.readBatch.get(len(ps))
+	for i := range ms {
+		ms[i].Buffers[0] = ps[i].Buf
+		if ms[i].OOB == nil {
+			ms[i].OOB = ipv4.NewControlMessage(ipv4.FlagInterface | ipv4.FlagDst)
+		}
+		ms[i].OOB = ms[i].OOB[:cap(ms[i].OOB)]
+	}
+
+	n, err := p.ipv4pc.ReadBatch(ms, 0)
+	if n < 0 {
+		n = 0
+	}
+	for i := 0; i < n; i++ {
+		ps[i].N = ms[i].N
+		ps[i].Addr = ms[i].Addr
+		ps[i].IfIndex = -1
+		ps[i].Dst = nil
+
+		var cm ipv4.ControlMessage
+		if cm.Parse(ms[i].OOB[:ms[i].NN]) == nil {
+			ps[i].IfIndex = cm.IfIndex
+			ps[i].Dst = cm.Dst
+		}
+	}
+
+	// Don't keep the callers' buffers and addresses alive
+	for i := range ms {
+		ms[i].Buffers[0] = nil
+		ms[i].Addr = nil
+	}
+	return n, err
+}
+
+// WriteBatch writes the packets, each over the network interface with the
+// specified index. Like WriteToSrc, it writes a packet again with a source
+// address the kernel picks if its source address is not an address of the
+// host.
+func (p *packetConn) WriteBatch(ps []BatchPacket) (int, error) {
+	p.writeBatch.mu.Lock()
+	defer p.writeBatch.mu.Unlock()
+
+	ms := p.writeBatch.get(len(ps))
+	for i := range ms {
+		// Replies are mostly sent over the same interfaces from the same
+		// addresses, so the OOB data is only marshaled when they change
+		cm := &p.writeBatch.cms[i]
+		if ms[i].OOB == nil || cm.IfIndex != ps[i].IfIndex || !cm.Src.Equal(ps[i].Src) {
+			*cm = ipv4.ControlMessage{IfIndex: ps[i].IfIndex, Src: append(net.IP(nil), ps[i].Src...)}
+			ms[i].OOB = cm.Marshal()
+		}
+		ms[i].Buffers[0] = ps[i].Buf
+		ms[i].Addr = ps[i].Addr
+	}
+
+	n, err := p.ipv4pc.WriteBatch(ms, 0)
+	for i := range ms {
+		ms[i].Buffers[0] = nil
+		ms[i].Addr = nil
+	}
+
+	if n < 0 {
+		n = 0
+	}
+	if err != nil && n < len(ps) && ps[n].Src != nil {
+		if _, err := p.WriteToSrc(ps[n].Buf, ps[n].Addr, ps[n].Src, ps[n].IfIndex); err == nil {
+			return n + 1, nil
+		}
+	}
+	return n, err
+}
+
+// ListenReusePort returns n PacketConns listening on addr with SO_REUSEPORT,
+// which is only supported on Linux. The kernel spreads the packets sent to
+// addr over the connections, by source address and port, so that they can be
+// read in parallel. If addr has port 0, all connections listen on the port
+// chosen for the first one.
+func ListenReusePort(addr string, n int) ([]PacketConn, error) {
+	if addr == "" {
+		addr = ":67"
+	}
+
+	var conns []PacketConn
+	closeAll := func() {
+		for _, c := range conns {
+			c.Close()
+		}
+	}
+
+	for i := 0; i < n; i++ {
+		l, err := listenReusePort(addr)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		c, err := NewPacketConn(l)
+		if err != nil {
+			l.Close()
+			closeAll()
+			return nil, err
+		}
+		addr = l.LocalAddr().String()
+		conns = append(conns, c)
+	}
+	return conns, nil
+}
+
+// ListenAndServeReusePort listens on s.Addr with n connections, using
+// ListenReusePort, and serves them all with a reader each. Set BatchSize and
+// Workers for the best throughput.
+//
+// It returns when serving any of the connections fails, after the others have
+// stopped being served. The connections are closed when it returns.
+func (s *Server) ListenAndServeReusePort(ctx context.Context, n int) error {
+	conns, err := ListenReusePort(s.Addr, n)
+	if err != nil {
+		return err
+	}
+	return s.serveAll(ctx, conns)
+}
+
+// serveBatch is Serve for connections that can read batches of packets.
+func (s *Server) serveBatch(ctx context.Context, pc PacketConn, bc BatchConn) error {
+	w := &batchWriter{PacketConn: pc, bc: bc, max: s.BatchSize}
+
+	ps := make([]BatchPacket, s.BatchSize)
+	for i := range ps {
+		ps[i].Buf = make([]byte, batchBufferSize)
+	}
+
+	for {
+		n, err := bc.ReadBatch(ps)
+		for i := 0; i < n; i++ {
+			s.serveDHCP(pc, w, ps[i].Buf[:ps[i].N], ps[i].Addr, ps[i].IfIndex, ps[i].Dst)
+		}
+		if err != nil {
+			return s.readError(ctx, pc, err)
+		}
+	}
+}
+
+// batchWriter writes the replies of concurrent handlers in batches. Replies
+// are queued, and the handler whose reply is first in the queue writes a batch
+// starting with it, including the replies other handlers queued in the
+// meantime. It then hands the queue over to the handler whose reply is next.
+// This doesn't add latency, since no handler ever waits for replies that are
+// not queued yet.
+type batchWriter struct {
+	PacketConn
+	bc  BatchConn
+	max int
+
+	mu      sync.Mutex
+	queue   []*batchWrite
+	writing bool
+}
+
+type batchWrite struct {
+	p    BatchPacket
+	done chan error
+}
+
+// errBatchWriter is sent to a queued write to make it write the next batch.
+var errBatchWriter = errors.New("dhcp4: write the next batch")
+
+func (w *batchWriter) WriteTo(b []byte, addr net.Addr, ifindex int) (int, error) {
+	return w.WriteToSrc(b, addr, nil, ifindex)
+}
+
+func (w *batchWriter) WriteToSrc(b []byte, addr net.Addr, src net.IP, ifindex int) (int, error) {
+	bw := &batchWrite{
+		p:    BatchPacket{Buf: b, Addr: addr, IfIndex: ifindex, Src: src},
+		done: make(chan error, 1),
+	}
+
+	w.mu.Lock()
+	w.queue = append(w.queue, bw)
+	first := !w.writing
+	w.writing = true
+	w.mu.Unlock()
+
+	if first {
+		w.flush()
+	}
+
+	err := <-bw.done
+	if err == errBatchWriter {
+		w.flush()
+		err = <-bw.done
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// flush writes the batch at the head of the queue, and hands the queue over
+// to the next write, if any.
+func (w *batchWriter) flush() {
+	w.mu.Lock()
+	batch := w.queue
+	if len(batch) > w.max {
+		batch = batch[:w.max]
+	}
+	w.queue = w.queue[len(batch):]
+	w.mu.Unlock()
+
+	ps := make([]BatchPacket, len(batch))
+	for i, bw := range batch {
+		ps[i] = bw.p
+	}
+
+	var err error
+	for len(ps) > 0 && err == nil {
+		var n int
+		n, err = w.bc.WriteBatch(ps)
+		if n == 0 && err == nil {
+			err = io.ErrShortWrite
+		}
+		for _, bw := range batch[:n] {
+			bw.done <- nil
+		}
+		ps, batch = ps[n:], batch[n:]
+	}
+	for _, bw := range batch {
+		bw.done <- err
+	}
+
+	w.mu.Lock()
+	if len(w.queue) > 0 {
+		w.queue[0].done <- errBatchWriter
+	} else {
+		w.writing = false
+	}
+	w.mu.Unlock()
+}
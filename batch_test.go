@@ -0,0 +1,380 @@
+package dhcp4
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testBatchConn records the batches written to it. The first WriteBatch call
+// blocks until block is closed, if set.
+type testBatchConn struct {
+	PacketConn
+
+	block   chan struct{}
+	entered chan struct{}
+	limit   int
+	err     error
+
+	mu      sync.Mutex
+	batches [][]BatchPacket
+}
+
+func (c *testBatchConn) ReadBatch(ps []BatchPacket) (int, error) {
+	return 0, errors.New("not implemented")
+}
+
+func (c *testBatchConn) WriteBatch(ps []BatchPacket) (int, error) {
+	c.mu.Lock()
+	first := len(c.batches) == 0
+	c.mu.Unlock()
+
+	if first && c.block != nil {
+		close(c.entered)
+		<-c.block
+	}
+
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.limit > 0 && len(ps) > c.limit {
+		ps = ps[:c.limit]
+	}
+
+	c.mu.Lock()
+	c.batches = append(c.batches, append([]BatchPacket(nil), ps...))
+	c.mu.Unlock()
+	return len(ps), nil
+}
+
+func TestBatchWriter(t *testing.T) {
+	bc := &testBatchConn{
+		block:   make(chan struct{}),
+		entered: make(chan struct{}),
+		limit:   3,
+	}
+	w := &batchWriter{bc: bc, max: 4}
+
+	var wg sync.WaitGroup
+	write := func(i int) {
+		defer wg.Done()
+		n, err := w.WriteTo([]byte{byte(i)}, nil, i)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, n)
+	}
+
+	// The first write is written alone, while the others queue up
+	wg.Add(1)
+	go write(0)
+	<-bc.entered
+
+	for i := 1; i <= 6; i++ {
+		wg.Add(1)
+		go write(i)
+	}
+	for {
+		w.mu.Lock()
+		n := len(w.queue)
+		w.mu.Unlock()
+		if n == 6 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	close(bc.block)
+	wg.Wait()
+
+	// Batches hold at most 4 packets, of which 3 are written at once
+	var sizes []int
+	written := make(map[int]bool)
+	for _, batch := range bc.batches {
+		sizes = append(sizes, len(batch))
+		for _, p := range batch {
+			written[p.IfIndex] = true
+		}
+	}
+	assert.Equal(t, []int{1, 3, 1, 2}, sizes)
+	assert.Len(t, written, 7)
+
+	w.mu.Lock()
+	assert.False(t, w.writing)
+	w.mu.Unlock()
+}
+
+func TestBatchWriterError(t *testing.T) {
+	bc := &testBatchConn{err: errors.New("write failed")}
+	w := &batchWriter{bc: bc, max: 4}
+
+	_, err := w.WriteTo([]byte{1}, nil, 1)
+	assert.Equal(t, bc.err, err)
+
+	w.mu.Lock()
+	assert.False(t, w.writing)
+	assert.Empty(t, w.queue)
+	w.mu.Unlock()
+}
+
+func TestPacketConnBatch(t *testing.T) {
+	pc, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer pc.Close()
+
+	bc, ok := pc.(BatchConn)
+	if !assert.True(t, ok) {
+		return
+	}
+
+	client, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	out := []BatchPacket{
+		{Buf: []byte("one"), Addr: client.LocalAddr(), IfIndex: lo.Index},
+		{Buf: []byte("two"), Addr: client.LocalAddr(), IfIndex: lo.Index},
+	}
+	n, err := bc.WriteBatch(out)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	// The messages and their OOB data are reused by the next write
+	wb := &pc.(*packetConn).writeBatch
+	oob := &wb.ms[0].OOB[0]
+	n, err = bc.WriteBatch(out[:1])
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.True(t, oob == &wb.ms[0].OOB[0])
+	assert.Nil(t, wb.ms[0].Buffers[0])
+
+	b := make([]byte, 16)
+	for _, expected := range []string{"one", "two", "one"} {
+		n, _, err := client.ReadFrom(b)
+		if assert.NoError(t, err) {
+			assert.Equal(t, expected, string(b[:n]))
+		}
+	}
+
+	for _, s := range []string{"three", "four"} {
+		client.WriteTo([]byte(s), pc.(*packetConn).LocalAddr())
+	}
+
+	in := []BatchPacket{{Buf: make([]byte, 16)}, {Buf: make([]byte, 16)}}
+	var read []string
+	for len(read) < 2 {
+		n, err := bc.ReadBatch(in[len(read):])
+		if !assert.NoError(t, err) {
+			return
+		}
+		for _, p := range in[len(read) : len(read)+n] {
+			read = append(read, string(p.Buf[:p.N]))
+			assert.Equal(t, client.LocalAddr().String(), p.Addr.String())
+			assert.Equal(t, lo.Index, p.IfIndex)
+		}
+	}
+	assert.Equal(t, []string{"three", "four"}, read)
+}
+
+func TestListenReusePort(t *testing.T) {
+	conns, err := ListenReusePort("127.0.0.1:0", 3)
+	if err == errReusePortUnsupported {
+		t.Skip(err)
+	}
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() {
+		for _, c := range conns {
+			c.Close()
+		}
+	}()
+
+	if assert.Len(t, conns, 3) {
+		addr := conns[0].(*packetConn).LocalAddr().String()
+		for _, c := range conns[1:] {
+			assert.Equal(t, addr, c.(*packetConn).LocalAddr().String())
+		}
+	}
+}
+
+// offerHandler offers an address to every DISCOVER.
+var offerHandler = HandlerFunc(func(w ReplyWriter, p *Packet) {
+	offer := CreateOffer(p)
+	offer.SetYIAddr(net.IP{10, 0, 0, 10})
+	offer.SetIP(OptionSubnetMask, net.IP{255, 255, 255, 0})
+	offer.SetIP(OptionDHCPServerID, net.IP{10, 0, 0, 1})
+	offer.SetDuration(OptionAddressTime, time.Hour)
+	w.WriteReply(&offer)
+})
+
+// newRelayedDiscover returns a DISCOVER relayed by 127.0.0.1, with the Relay
+// Source Port sub-option so that the reply is sent to the source port.
+func newRelayedDiscover(t testing.TB, xid uint32) []byte {
+	p := NewPacket(BootRequest)
+	p.HType()[0] = 1
+	p.HLen()[0] = 6
+	p.XID()[0] = byte(xid >> 24)
+	p.XID()[1] = byte(xid >> 16)
+	p.XID()[2] = byte(xid >> 8)
+	p.XID()[3] = byte(xid)
+	p.SetGIAddr(net.IP{127, 0, 0, 1})
+	p.SetMessageType(MessageTypeDiscover)
+	p.SetOption(OptionRelayAgentInformation, []byte{byte(RelayAgentSourcePort), 0})
+
+	b, err := PacketToBytes(p, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestServerServeBatch(t *testing.T) {
+	pc, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	s := Server{Handler: offerHandler, Workers: 4, BatchSize: 8}
+	errc := make(chan error)
+	go func() {
+		errc <- s.Serve(context.Background(), pc)
+	}()
+
+	client, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+
+	const count = 32
+	for i := 0; i < count; i++ {
+		client.WriteTo(newRelayedDiscover(t, uint32(i)), pc.(*packetConn).LocalAddr())
+	}
+
+	xids := make(map[byte]bool)
+	b := make([]byte, 1500)
+	for len(xids) < count {
+		n, _, err := client.ReadFrom(b)
+		if !assert.NoError(t, err) {
+			break
+		}
+		p, err := PacketFromBytes(b[:n])
+		if assert.NoError(t, err) {
+			assert.Equal(t, MessageTypeOffer, p.GetMessageType())
+			xids[p.XID()[3]] = true
+		}
+	}
+
+	assert.NoError(t, s.Shutdown(context.Background()))
+	assert.Equal(t, ErrServerClosed, <-errc)
+}
+
+// BenchmarkServerLoopback measures the packets per second a server answers on
+// loopback, with clients keeping a window of requests in flight.
+func BenchmarkServerLoopback(b *testing.B) {
+	for _, bm := range []struct {
+		shards, batch int
+	}{
+		{1, 1},
+		{1, 32},
+		{4, 1},
+		{4, 32},
+	} {
+		b.Run(fmt.Sprintf("shards=%d/batch=%d", bm.shards, bm.batch), func(b *testing.B) {
+			benchmarkServerLoopback(b, bm.shards, bm.batch)
+		})
+	}
+}
+
+func benchmarkServerLoopback(b *testing.B, shards, batch int) {
+	const (
+		clients = 8
+		window  = 16
+	)
+
+	conns, err := ListenReusePort("127.0.0.1:0", shards)
+	if err != nil {
+		b.Skip(err)
+	}
+	addr := conns[0].(*packetConn).LocalAddr()
+
+	s := Server{Handler: offerHandler, Workers: 8, BatchSize: batch}
+	done := make(chan struct{})
+	go func() {
+		s.serveAll(context.Background(), conns)
+		close(done)
+	}()
+	defer func() {
+		s.Shutdown(context.Background())
+		<-done
+	}()
+
+	msg := newRelayedDiscover(b, 1)
+	var received sync.WaitGroup
+	var mu sync.Mutex
+	var total int
+
+	b.ResetTimer()
+	start := time.Now()
+
+	for i := 0; i < clients; i++ {
+		n := b.N / clients
+		if i < b.N%clients {
+			n++
+		}
+
+		received.Add(1)
+		go func(n int) {
+			defer received.Done()
+
+			c, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			if err != nil {
+				b.Error(err)
+				return
+			}
+			defer c.Close()
+
+			// Replies are lost when the server drops requests; after a
+			// timeout, the requests in flight are sent again
+			sent, got := 0, 0
+			buf := make([]byte, 1500)
+			deadline := time.Now().Add(10 * time.Second)
+			for got < n && time.Now().Before(deadline) {
+				for ; sent < n && sent-got < window; sent++ {
+					c.WriteTo(msg, addr)
+				}
+
+				c.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+				if _, _, err := c.ReadFrom(buf); err != nil {
+					sent = got
+					continue
+				}
+				got++
+			}
+
+			mu.Lock()
+			total += got
+			mu.Unlock()
+		}(n)
+	}
+
+	received.Wait()
+	b.StopTimer()
+	b.ReportMetric(float64(total)/time.Since(start).Seconds(), "pkts/s")
+}
@@ -0,0 +1,253 @@
+package dhcp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+)
+
+var ErrInvalidClientID = errors.New("dhcp4: invalid client identifier")
+
+// ClientIDTypeOpaque is the client identifier type for identifiers that are
+// not hardware addresses (RFC2132, section 9.14).
+const ClientIDTypeOpaque = uint8(0)
+
+// ClientIDTypeRFC4361 is the client identifier type for identifiers made up
+// of an IAID and a DUID (RFC4361, section 6.1).
+const ClientIDTypeRFC4361 = uint8(255)
+
+// DUIDType is the type for the various DUID formats defined in RFC3315.
+type DUIDType uint16
+
+const (
+	DUIDTypeLLT  = DUIDType(1)
+	DUIDTypeEN   = DUIDType(2)
+	DUIDTypeLL   = DUIDType(3)
+	DUIDTypeUUID = DUIDType(4)
+)
+
+var duidTypeStrings = map[DUIDType]string{
+	DUIDTypeLLT:  "llt",
+	DUIDTypeEN:   "en",
+	DUIDTypeLL:   "ll",
+	DUIDTypeUUID: "uuid",
+}
+
+func (t DUIDType) String() string {
+	if s, ok := duidTypeStrings[t]; ok {
+		return s
+	}
+	return fmt.Sprintf("duid(%d)", t)
+}
+
+// DUID is a DHCP Unique Identifier, as defined in RFC3315 section 9 and
+// RFC6355. Only the fields relevant to its type are set.
+type DUID struct {
+	Type DUIDType
+
+	// HardwareType and HardwareAddr are set for DUID-LLT and DUID-LL.
+	HardwareType uint16
+	HardwareAddr net.HardwareAddr
+
+	// Time is set for DUID-LLT, in seconds since midnight (UTC), January 1,
+	// 2000, modulo 2^32.
+	Time uint32
+
+	// EnterpriseNumber and Identifier are set for DUID-EN.
+	EnterpriseNumber uint32
+	Identifier       []byte
+
+	// UUID is set for DUID-UUID.
+	UUID []byte
+
+	raw []byte
+}
+
+// ParseDUID parses the wire-level representation of a DUID. DUIDs of an
+// unknown type are accepted and only carry their type and raw bytes.
+func ParseDUID(b []byte) (DUID, error) {
+	if len(b) < 2 {
+		return DUID{}, ErrInvalidClientID
+	}
+
+	d := DUID{
+		Type: DUIDType(binary.BigEndian.Uint16(b[0:2])),
+		raw:  b,
+	}
+
+	v := b[2:]
+	switch d.Type {
+	case DUIDTypeLLT:
+		if len(v) < 6 {
+			return DUID{}, ErrInvalidClientID
+		}
+		d.HardwareType = binary.BigEndian.Uint16(v[0:2])
+		d.Time = binary.BigEndian.Uint32(v[2:6])
+		d.HardwareAddr = net.HardwareAddr(v[6:])
+	case DUIDTypeEN:
+		if len(v) < 4 {
+			return DUID{}, ErrInvalidClientID
+		}
+		d.EnterpriseNumber = binary.BigEndian.Uint32(v[0:4])
+		d.Identifier = v[4:]
+	case DUIDTypeLL:
+		if len(v) < 2 {
+			return DUID{}, ErrInvalidClientID
+		}
+		d.HardwareType = binary.BigEndian.Uint16(v[0:2])
+		d.HardwareAddr = net.HardwareAddr(v[2:])
+	case DUIDTypeUUID:
+		if len(v) != 16 {
+			return DUID{}, ErrInvalidClientID
+		}
+		d.UUID = v
+	}
+
+	return d, nil
+}
+
+// Bytes returns the wire-level representation of the DUID.
+func (d DUID) Bytes() []byte {
+	return d.raw
+}
+
+func (d DUID) String() string {
+	switch d.Type {
+	case DUIDTypeLLT:
+		return fmt.Sprintf("%s:%d:%d:%s", d.Type, d.HardwareType, d.Time, d.HardwareAddr)
+	case DUIDTypeEN:
+		return fmt.Sprintf("%s:%d:%x", d.Type, d.EnterpriseNumber, d.Identifier)
+	case DUIDTypeLL:
+		return fmt.Sprintf("%s:%d:%s", d.Type, d.HardwareType, d.HardwareAddr)
+	case DUIDTypeUUID:
+		return fmt.Sprintf("%s:%s", d.Type, formatUUID(d.UUID))
+	}
+	return fmt.Sprintf("%s:%x", d.Type, d.raw[2:])
+}
+
+// ClientID is the parsed representation of the client identifier option
+// (option 61). It distinguishes between identifiers carrying a hardware
+// address (RFC2132, section 9.14), identifiers carrying an IAID and DUID
+// (RFC4361, section 6.1), and identifiers that are opaque to the server.
+//
+// Two client identifiers identify the same client if and only if their wire
+// representations are equal. Use Equal to compare them, or Key to use them as
+// map keys.
+type ClientID struct {
+	// Type is the first octet of the client identifier. It holds an ARP
+	// hardware type for hardware identifiers, ClientIDTypeRFC4361 for RFC4361
+	// identifiers, and ClientIDTypeOpaque (or any other value) otherwise.
+	Type uint8
+
+	// HardwareAddr is set for identifiers of type 1 (Ethernet).
+	HardwareAddr net.HardwareAddr
+
+	// IAID and DUID are set for RFC4361 identifiers.
+	IAID uint32
+	DUID DUID
+
+	raw []byte
+}
+
+// ParseClientID parses the value of the client identifier option. RFC4361
+// identifiers that do not contain a valid DUID are treated as opaque.
+func ParseClientID(b []byte) (ClientID, error) {
+	// From RFC2132, section 9.14: the minimum length is 2.
+	if len(b) < 2 {
+		return ClientID{}, ErrInvalidClientID
+	}
+
+	c := ClientID{
+		Type: b[0],
+		raw:  append([]byte(nil), b...),
+	}
+
+	switch c.Type {
+	case 1:
+		// Ethernet (MAC-48) addresses are 6 octets.
+		if len(b) == 7 {
+			c.HardwareAddr = net.HardwareAddr(c.raw[1:])
+		}
+	case ClientIDTypeRFC4361:
+		if len(b) < 5 {
+			break
+		}
+		d, err := ParseDUID(c.raw[5:])
+		if err != nil {
+			break
+		}
+		c.IAID = binary.BigEndian.Uint32(c.raw[1:5])
+		c.DUID = d
+	}
+
+	return c, nil
+}
+
+// NewHardwareClientID returns the client identifier equivalent to a hardware
+// type and client hardware address. Per RFC2131, section 4.2, this identifies
+// a client that did not send a client identifier option.
+func NewHardwareClientID(htype uint8, addr net.HardwareAddr) ClientID {
+	c := ClientID{
+		Type: htype,
+		raw:  append([]byte{htype}, addr...),
+	}
+
+	if htype == 1 && len(addr) == 6 {
+		c.HardwareAddr = net.HardwareAddr(c.raw[1:])
+	}
+
+	return c
+}
+
+// IsHardware returns whether the client identifier holds a hardware address.
+func (c ClientID) IsHardware() bool {
+	return c.HardwareAddr != nil
+}
+
+// IsRFC4361 returns whether the client identifier holds an IAID and DUID.
+func (c ClientID) IsRFC4361() bool {
+	return c.DUID.raw != nil
+}
+
+// Bytes returns the wire-level representation of the client identifier.
+func (c ClientID) Bytes() []byte {
+	return c.raw
+}
+
+// Equal returns whether c and o identify the same client.
+func (c ClientID) Equal(o ClientID) bool {
+	return bytes.Equal(c.raw, o.raw)
+}
+
+// Key returns a string that uniquely represents the client identifier, for
+// use as a map key.
+func (c ClientID) Key() string {
+	return string(c.raw)
+}
+
+func (c ClientID) String() string {
+	switch {
+	case c.raw == nil:
+		return ""
+	case c.IsHardware():
+		return fmt.Sprintf("hw:%d:%s", c.Type, c.HardwareAddr)
+	case c.IsRFC4361():
+		return fmt.Sprintf("iaid:%08x:%s", c.IAID, c.DUID)
+	}
+	return fmt.Sprintf("%d:%x", c.Type, c.raw[1:])
+}
+
+// GetClientID gets the identity of the client that sent the packet. It is
+// taken from the client identifier option if present and valid, and derived
+// from the hardware type and client hardware address otherwise.
+func (p Packet) GetClientID() ClientID {
+	if v, ok := p.GetOption(OptionClientID); ok {
+		if c, err := ParseClientID(v); err == nil {
+			return c
+		}
+	}
+
+	return NewHardwareClientID(p.GetHType(), p.GetCHAddr())
+}
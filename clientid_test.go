@@ -0,0 +1,146 @@
+package dhcp4
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseClientIDHardware(t *testing.T) {
+	b := []byte{1, 0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+
+	c, err := ParseClientID(b)
+	if assert.NoError(t, err) {
+		assert.True(t, c.IsHardware())
+		assert.False(t, c.IsRFC4361())
+		assert.Equal(t, uint8(1), c.Type)
+		assert.Equal(t, net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}, c.HardwareAddr)
+		assert.Equal(t, "hw:1:00:11:22:33:44:55", c.String())
+	}
+}
+
+func TestParseClientIDRFC4361(t *testing.T) {
+	iaid := []byte{0x01, 0x02, 0x03, 0x04}
+	mac := []byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+
+	testCases := []struct {
+		duid     []byte
+		expected DUID
+		str      string
+	}{
+		{
+			duid: append([]byte{0, 1, 0, 1, 0x1d, 0x2e, 0x3f, 0x40}, mac...),
+			expected: DUID{
+				Type:         DUIDTypeLLT,
+				HardwareType: 1,
+				Time:         0x1d2e3f40,
+				HardwareAddr: mac,
+			},
+			str: "iaid:01020304:llt:1:489570112:00:11:22:33:44:55",
+		},
+		{
+			duid: []byte{0, 2, 0, 0, 0x0d, 0xe9, 0xca, 0xfe},
+			expected: DUID{
+				Type:             DUIDTypeEN,
+				EnterpriseNumber: 3561,
+				Identifier:       []byte{0xca, 0xfe},
+			},
+			str: "iaid:01020304:en:3561:cafe",
+		},
+		{
+			duid: append([]byte{0, 3, 0, 1}, mac...),
+			expected: DUID{
+				Type:         DUIDTypeLL,
+				HardwareType: 1,
+				HardwareAddr: mac,
+			},
+			str: "iaid:01020304:ll:1:00:11:22:33:44:55",
+		},
+		{
+			duid: []byte{0, 4, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+			expected: DUID{
+				Type: DUIDTypeUUID,
+				UUID: []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+			},
+			str: "iaid:01020304:uuid:00010203-0405-0607-0809-0a0b0c0d0e0f",
+		},
+	}
+
+	for _, testCase := range testCases {
+		b := append(append([]byte{255}, iaid...), testCase.duid...)
+
+		c, err := ParseClientID(b)
+		if !assert.NoError(t, err) {
+			continue
+		}
+
+		testCase.expected.raw = testCase.duid
+		assert.True(t, c.IsRFC4361())
+		assert.False(t, c.IsHardware())
+		assert.Equal(t, uint32(0x01020304), c.IAID)
+		assert.Equal(t, testCase.expected, c.DUID)
+		assert.Equal(t, testCase.str, c.String())
+	}
+}
+
+func TestParseClientIDOpaque(t *testing.T) {
+	testCases := [][]byte{
+		[]byte("\x00foo"),
+		// Type 1 with an address that is not MAC-48
+		{1, 0x00, 0x11},
+		// Type 255 with a DUID that is too short
+		{255, 1, 2, 3, 4, 0},
+		// Type 255 with a DUID-UUID that has the wrong length
+		{255, 1, 2, 3, 4, 0, 4, 0, 1},
+	}
+
+	for _, b := range testCases {
+		c, err := ParseClientID(b)
+		if assert.NoError(t, err) {
+			assert.False(t, c.IsHardware())
+			assert.False(t, c.IsRFC4361())
+			assert.Equal(t, b, c.Bytes())
+		}
+	}
+}
+
+func TestParseClientIDTooShort(t *testing.T) {
+	for _, b := range [][]byte{nil, {1}} {
+		_, err := ParseClientID(b)
+		assert.Equal(t, ErrInvalidClientID, err)
+	}
+}
+
+func TestClientIDEqual(t *testing.T) {
+	mac := net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+
+	a, _ := ParseClientID(append([]byte{1}, mac...))
+	b := NewHardwareClientID(1, mac)
+	c, _ := ParseClientID([]byte("\x00foo"))
+
+	assert.True(t, a.Equal(b))
+	assert.Equal(t, a.Key(), b.Key())
+	assert.False(t, a.Equal(c))
+	assert.NotEqual(t, a.Key(), c.Key())
+}
+
+func TestPacketGetClientID(t *testing.T) {
+	mac := net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+
+	p := NewPacket(BootRequest)
+	p.HType()[0] = 1
+	p.HLen()[0] = 6
+	copy(p.CHAddr(), mac)
+
+	// Derived from chaddr without option 61
+	assert.Equal(t, NewHardwareClientID(1, mac), p.GetClientID())
+
+	// Invalid option 61 is ignored
+	p.SetOption(OptionClientID, []byte{1})
+	assert.Equal(t, NewHardwareClientID(1, mac), p.GetClientID())
+
+	// Valid option 61 takes precedence
+	p.SetOption(OptionClientID, []byte("\x00foo"))
+	assert.Equal(t, []byte("\x00foo"), p.GetClientID().Bytes())
+}
@@ -1,5 +1,11 @@
 package dhcp4
 
+import (
+	"errors"
+	"net"
+	"time"
+)
+
 // Reply defines an interface implemented by DHCP replies.
 type Reply interface {
 	Validate() error
@@ -11,8 +17,79 @@ type Reply interface {
 	OptionSetter
 }
 
+// ReplyAppender is implemented by Replies that can serialize into a buffer,
+// such as Offer, Ack and Nak. The server serializes them into reused buffers
+// instead of calling ToBytes, so AppendTo must write the same bytes.
+type ReplyAppender interface {
+	AppendTo(dst []byte) ([]byte, error)
+}
+
+// RequestInfo describes how a request was received.
+type RequestInfo struct {
+	// Src is the source address of the request. It is the address of the
+	// relay agent for relayed requests.
+	Src *net.UDPAddr
+
+	// IfIndex is the index of the interface the request arrived on.
+	IfIndex int
+
+	// IfName and IfAddr are the name and the primary IPv4 address of the
+	// interface the request arrived on. They are only set if the PacketConn
+	// implements InterfaceLookup, as the ones returned by Listen and
+	// ListenInterface do.
+	IfName string
+	IfAddr net.IP
+
+	// Dst is the local address the request was sent to: an address of the
+	// server for unicast requests, or a broadcast address. It is only set if
+	// the PacketConn implements DstReader. Pass it to NewRequest to tell
+	// RENEWING from REBINDING clients; RequestValidator does so itself.
+	Dst net.IP
+
+	// Received is the time the request was read off the network.
+	Received time.Time
+
+	// ServerID is the server identifier derived for the request, which
+	// SetServerID sets in replies. See ServerIDs for how it is derived.
+	ServerID net.IP
+}
+
+// ErrNoReply is returned by WriteReply for requests that must not be answered,
+// such as DHCPDECLINE and DHCPRELEASE.
+var ErrNoReply = errors.New("dhcp4: message must not be answered")
+
 // ReplyWriter defines an interface for the object that writes a reply to the
 // network to the intended received, be it via broadcast or unicast.
 type ReplyWriter interface {
 	WriteReply(r Reply) error
+
+	// Info returns how the request was received. It never returns nil.
+	Info() *RequestInfo
+}
+
+// copyClientID copies the client identifier from msg to rep, and returns
+// whether it does: requests read by a Server configured with
+// DisableClientIDEcho don't have it echoed.
+//
+// From RFC6842, section 3: if the 'client identifier' option is present in a
+// message received from a client, the server MUST return the 'client
+// identifier' option, unaltered, in its response message.
+func copyClientID(rep, msg *Packet) bool {
+	if msg.noClientIDEcho {
+		return false
+	}
+
+	if v, ok := msg.GetOption(OptionClientID); ok {
+		rep.SetOption(OptionClientID, append([]byte(nil), v...))
+	}
+	return true
+}
+
+// clientIDValidation returns the validation for the client identifier in
+// DHCPOFFER and DHCPACK replies.
+func clientIDValidation(msg *Packet, echo bool) Validation {
+	if echo {
+		return ValidateEcho(OptionClientID, msg)
+	}
+	return ValidateMustNot(OptionClientID)
 }
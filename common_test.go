@@ -66,9 +66,14 @@ func (r replyValidationTestCase) Test(t *testing.T) {
 
 type testReplyWriter struct {
 	wrote bool
+	info  RequestInfo
 }
 
 func (t *testReplyWriter) WriteReply(r Reply) error {
 	t.wrote = true
 	return nil
 }
+
+func (t *testReplyWriter) Info() *RequestInfo {
+	return &t.info
+}
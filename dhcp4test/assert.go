@@ -0,0 +1,78 @@
+package dhcp4test
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	dhcp4 "github.com/ydp/dhcp4-go"
+)
+
+// The assertion helpers below report a failure with t.Errorf and return
+// whether the assertion held, so that tests can stop early when it did not.
+
+// AssertMessageType asserts that p has message type expected.
+func AssertMessageType(t testing.TB, p *dhcp4.Packet, expected dhcp4.MessageType) bool {
+	t.Helper()
+
+	if actual := p.GetMessageType(); actual != expected {
+		t.Errorf("message type: expected %s, got %s", expected, actual)
+		return false
+	}
+	return true
+}
+
+// AssertYIAddr asserts that the 'yiaddr' field of p is expected.
+func AssertYIAddr(t testing.TB, p *dhcp4.Packet, expected net.IP) bool {
+	t.Helper()
+
+	if actual := p.GetYIAddr(); !actual.Equal(expected) {
+		t.Errorf("yiaddr: expected %s, got %s", expected, actual)
+		return false
+	}
+	return true
+}
+
+// AssertOption asserts that p has option o with value expected.
+func AssertOption(t testing.TB, p *dhcp4.Packet, o dhcp4.Option, expected []byte) bool {
+	t.Helper()
+
+	actual, ok := p.GetOption(o)
+	if !ok {
+		t.Errorf("option %d: expected %v, got none", o, expected)
+		return false
+	}
+	if !bytes.Equal(actual, expected) {
+		t.Errorf("option %d: expected %v, got %v", o, expected, actual)
+		return false
+	}
+	return true
+}
+
+// AssertIPOption asserts that p has option o with IP address expected.
+func AssertIPOption(t testing.TB, p *dhcp4.Packet, o dhcp4.Option, expected net.IP) bool {
+	t.Helper()
+	return AssertOption(t, p, o, expected.To4())
+}
+
+// AssertNoOption asserts that p does not have option o.
+func AssertNoOption(t testing.TB, p *dhcp4.Packet, o dhcp4.Option) bool {
+	t.Helper()
+
+	if actual, ok := p.GetOption(o); ok {
+		t.Errorf("option %d: expected none, got %v", o, actual)
+		return false
+	}
+	return true
+}
+
+// AssertDst asserts that r was written to destination address expected.
+func AssertDst(t testing.TB, r *Received, expected *net.UDPAddr) bool {
+	t.Helper()
+
+	if r.Dst == nil || !r.Dst.IP.Equal(expected.IP) || r.Dst.Port != expected.Port {
+		t.Errorf("destination: expected %v, got %v", expected, r.Dst)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,119 @@
+package dhcp4test
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+
+	dhcp4 "github.com/ydp/dhcp4-go"
+)
+
+// Client is a simulated DHCP client attached to an Interface.
+type Client struct {
+	HardwareAddr net.HardwareAddr
+	Interface    *Interface
+
+	replies chan *Received
+}
+
+// NewPacket returns a request of message type t from the client, with a new
+// transaction ID.
+func (c *Client) NewPacket(t dhcp4.MessageType) dhcp4.Packet {
+	p := dhcp4.NewPacket(dhcp4.BootRequest)
+	p.HType()[0] = 1
+	p.HLen()[0] = byte(len(c.HardwareAddr))
+	copy(p.CHAddr(), c.HardwareAddr)
+	binary.BigEndian.PutUint32(p.XID(), c.Interface.network.nextXID())
+	p.SetMessageType(t)
+	return p
+}
+
+// Send sends a request to the server. The source address is 'ciaddr' if it
+// is set, and 0.0.0.0 otherwise.
+func (c *Client) Send(p dhcp4.Packet) error {
+	b, err := dhcp4.PacketToBytes(p, nil)
+	if err != nil {
+		return err
+	}
+
+	src := &net.UDPAddr{IP: net.IPv4zero, Port: dhcp4.ClientPort}
+	if ip := p.GetCIAddr(); !ip.Equal(net.IPv4zero) {
+		src.IP = ip
+	}
+
+	return c.Interface.network.Inject(b, src, c.Interface.Index)
+}
+
+// SendDiscover sends a DHCPDISCOVER and returns it.
+func (c *Client) SendDiscover() (dhcp4.Packet, error) {
+	p := c.NewPacket(dhcp4.MessageTypeDiscover)
+	return p, c.Send(p)
+}
+
+// SendRequest sends a DHCPREQUEST in SELECTING state for the address in
+// offer, with the same transaction ID, and returns it.
+func (c *Client) SendRequest(offer *dhcp4.Packet) (dhcp4.Packet, error) {
+	p := c.NewPacket(dhcp4.MessageTypeRequest)
+	copy(p.XID(), offer.XID())
+	p.SetIP(dhcp4.OptionAddressRequest, offer.GetYIAddr())
+	if id, ok := offer.GetOption(dhcp4.OptionDHCPServerID); ok {
+		p.SetOption(dhcp4.OptionDHCPServerID, id)
+	}
+	return p, c.Send(p)
+}
+
+// Receive waits for a reply with the same transaction ID as msg. Replies to
+// other transactions are discarded.
+func (c *Client) Receive(msg dhcp4.Packet, timeout time.Duration) (*Received, error) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for {
+		select {
+		case r := <-c.replies:
+			if string(r.XID()) == string(msg.XID()) {
+				return r, nil
+			}
+		case <-deadline.C:
+			return nil, ErrTimeout
+		}
+	}
+}
+
+// ErrUnexpectedReply is returned by DORA if the server does not answer with
+// the expected message type.
+var ErrUnexpectedReply = errors.New("dhcp4test: unexpected reply")
+
+// DORA runs the DISCOVER, OFFER, REQUEST, ACK exchange, and returns the
+// offer and the acknowledgement. It returns ErrUnexpectedReply along with the
+// reply if the server does not offer an address, or does not acknowledge it.
+func (c *Client) DORA(timeout time.Duration) (offer, ack *Received, err error) {
+	discover, err := c.SendDiscover()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	offer, err = c.Receive(discover, timeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	if offer.GetMessageType() != dhcp4.MessageTypeOffer {
+		return offer, nil, ErrUnexpectedReply
+	}
+
+	request, err := c.SendRequest(offer.Packet)
+	if err != nil {
+		return offer, nil, err
+	}
+
+	ack, err = c.Receive(request, timeout)
+	if err != nil {
+		return offer, nil, err
+	}
+	if ack.GetMessageType() != dhcp4.MessageTypeAck {
+		return offer, ack, ErrUnexpectedReply
+	}
+
+	return offer, ack, nil
+}
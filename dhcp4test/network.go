@@ -0,0 +1,306 @@
+// Package dhcp4test provides an in-memory network to test DHCP servers built
+// with package dhcp4, without sockets or privileges.
+//
+// A Network connects a single server side PacketConn to simulated clients on
+// virtual interfaces. Every interface behaves like a separate link: replies
+// the server writes on an interface are delivered to the client on that
+// interface whose hardware address matches the 'chaddr' field of the reply,
+// regardless of the destination IP address.
+package dhcp4test
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	dhcp4 "github.com/ydp/dhcp4-go"
+)
+
+// ErrTimeout is returned when no reply is received in time.
+var ErrTimeout = errors.New("dhcp4test: timeout waiting for reply")
+
+type datagram struct {
+	b       []byte
+	src     *net.UDPAddr
+	ifindex int
+}
+
+// Network is an in-memory network with a server and simulated clients.
+type Network struct {
+	conn *ServerConn
+
+	mu     sync.Mutex
+	ifaces []*Interface
+	xid    uint32
+}
+
+// NewNetwork returns a network without interfaces.
+func NewNetwork() *Network {
+	n := &Network{}
+	n.conn = &ServerConn{
+		network: n,
+		in:      make(chan datagram),
+		expired: make(chan struct{}),
+		closed:  make(chan struct{}),
+	}
+	return n
+}
+
+// ServerConn returns the server side of the network, to pass to Serve.
+func (n *Network) ServerConn() *ServerConn {
+	return n.conn
+}
+
+// AddInterface adds a virtual interface to the network. Interfaces are
+// numbered from 1, in the order they are added.
+func (n *Network) AddInterface(name string) *Interface {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	i := &Interface{
+		Index:   len(n.ifaces) + 1,
+		Name:    name,
+		network: n,
+	}
+	n.ifaces = append(n.ifaces, i)
+	return i
+}
+
+// Interface returns the interface with the specified index, or nil.
+func (n *Network) Interface(index int) *Interface {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if index < 1 || index > len(n.ifaces) {
+		return nil
+	}
+	return n.ifaces[index-1]
+}
+
+// Inject delivers a raw packet to the server as if it was received on the
+// interface with index ifindex, for example to simulate a relay agent or a
+// malformed packet. It blocks until the server reads the packet, or the
+// server side is closed.
+func (n *Network) Inject(b []byte, src *net.UDPAddr, ifindex int) error {
+	select {
+	case n.conn.in <- datagram{b: b, src: src, ifindex: ifindex}:
+		return nil
+	case <-n.conn.closed:
+		return net.ErrClosed
+	}
+}
+
+func (n *Network) nextXID() uint32 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.xid++
+	return n.xid
+}
+
+// Interface is a virtual interface, with simulated clients attached to it.
+type Interface struct {
+	Index int
+	Name  string
+
+	// Addr is the address of the server on the interface, which the server
+	// sees as the primary IPv4 address of the interface. Set it before the
+	// server reads requests from the interface.
+	Addr net.IP
+
+	network *Network
+
+	mu      sync.Mutex
+	clients []*Client
+}
+
+// NewClient attaches a simulated client to the interface.
+func (i *Interface) NewClient(mac net.HardwareAddr) *Client {
+	c := &Client{
+		HardwareAddr: mac,
+		Interface:    i,
+		replies:      make(chan *Received, 16),
+	}
+
+	i.mu.Lock()
+	i.clients = append(i.clients, c)
+	i.mu.Unlock()
+	return c
+}
+
+// deliver delivers a reply to the clients with a matching hardware address.
+func (i *Interface) deliver(r *Received) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	for _, c := range i.clients {
+		if !macEqual(c.HardwareAddr, r.GetCHAddr()) {
+			continue
+		}
+
+		// Like a real network, drop the reply if the client isn't reading
+		select {
+		case c.replies <- r:
+		default:
+		}
+	}
+}
+
+func macEqual(a, b net.HardwareAddr) bool {
+	return len(a) == len(b) && string(a) == string(b)
+}
+
+// Received is a reply received by a simulated client.
+type Received struct {
+	*dhcp4.Packet
+
+	// Dst is the destination address the server wrote the reply to.
+	Dst *net.UDPAddr
+
+	// HardwareAddr is set if the server unicast the reply to a link-layer
+	// address, using dhcp4.HardwareAddrWriter.
+	HardwareAddr net.HardwareAddr
+
+	// IfIndex is the index of the interface the reply was written on.
+	IfIndex int
+
+	// Src is the source address the server wrote the reply from, or nil if
+	// it left it to the kernel.
+	Src net.IP
+}
+
+// ServerConn is the server side of a Network. It implements
+// dhcp4.PacketConn, dhcp4.HardwareAddrWriter, dhcp4.SrcWriter and
+// dhcp4.InterfaceLookup, and supports read deadlines
+// so that a dhcp4.Server can be shut down gracefully.
+type ServerConn struct {
+	network *Network
+	in      chan datagram
+
+	mu       sync.Mutex
+	expired  chan struct{}
+	timer    *time.Timer
+	closed   chan struct{}
+	isClosed bool
+}
+
+// ReadFrom reads a packet sent by a simulated client.
+func (c *ServerConn) ReadFrom(b []byte) (int, net.Addr, int, error) {
+	c.mu.Lock()
+	expired := c.expired
+	c.mu.Unlock()
+
+	select {
+	case d := <-c.in:
+		return copy(b, d.b), d.src, d.ifindex, nil
+	case <-expired:
+		return 0, nil, -1, os.ErrDeadlineExceeded
+	case <-c.closed:
+		return 0, nil, -1, net.ErrClosed
+	}
+}
+
+// WriteTo delivers a reply to the simulated clients on the interface with
+// index ifindex.
+func (c *ServerConn) WriteTo(b []byte, addr net.Addr, ifindex int) (int, error) {
+	return c.write(b, addr, nil, nil, ifindex)
+}
+
+// WriteToSrc delivers a reply to the simulated clients on the interface with
+// index ifindex, recording the source address.
+func (c *ServerConn) WriteToSrc(b []byte, addr net.Addr, src net.IP, ifindex int) (int, error) {
+	return c.write(b, addr, src, nil, ifindex)
+}
+
+// WriteToHardwareAddr delivers a reply to the simulated clients on the
+// interface with index ifindex, recording the link-layer destination.
+func (c *ServerConn) WriteToHardwareAddr(b []byte, addr net.Addr, hw net.HardwareAddr, ifindex int) (int, error) {
+	return c.write(b, addr, nil, hw, ifindex)
+}
+
+func (c *ServerConn) write(b []byte, addr net.Addr, src net.IP, hw net.HardwareAddr, ifindex int) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+
+	i := c.network.Interface(ifindex)
+	if i == nil {
+		return 0, fmt.Errorf("dhcp4test: no interface with index %d", ifindex)
+	}
+
+	p, err := dhcp4.PacketFromBytes(b)
+	if err != nil {
+		return 0, err
+	}
+
+	dst, _ := addr.(*net.UDPAddr)
+	i.deliver(&Received{
+		Packet:       &p,
+		Dst:          dst,
+		HardwareAddr: hw,
+		IfIndex:      ifindex,
+		Src:          src,
+	})
+	return len(b), nil
+}
+
+// LookupInterface returns the name and the address of the server of the
+// interface with index ifindex.
+func (c *ServerConn) LookupInterface(ifindex int) (string, net.IP, bool) {
+	i := c.network.Interface(ifindex)
+	if i == nil {
+		return "", nil, false
+	}
+	return i.Name, i.Addr, true
+}
+
+// SetReadDeadline sets the deadline for ReadFrom. A zero value disables the
+// deadline.
+func (c *ServerConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+
+	expired := make(chan struct{})
+	c.expired = expired
+
+	if t.IsZero() {
+		return nil
+	}
+
+	d := time.Until(t)
+	if d <= 0 {
+		close(expired)
+		return nil
+	}
+
+	c.timer = time.AfterFunc(d, func() { close(expired) })
+	return nil
+}
+
+// Close closes the server side. Pending and future reads and writes fail.
+func (c *ServerConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.isClosed {
+		return net.ErrClosed
+	}
+	c.isClosed = true
+	close(c.closed)
+	return nil
+}
+
+// LocalAddr returns the address of the server side.
+func (c *ServerConn) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4zero, Port: dhcp4.ServerPort}
+}
@@ -0,0 +1,177 @@
+package dhcp4test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	dhcp4 "github.com/ydp/dhcp4-go"
+)
+
+var serverID = net.IP{10, 0, 0, 1}
+
+// newTestServer serves a fixed address per interface on n.
+func newTestServer(t *testing.T, n *Network) {
+	setOptions := func(r dhcp4.OptionSetter) {
+		r.SetIP(dhcp4.OptionDHCPServerID, serverID)
+		r.SetIP(dhcp4.OptionSubnetMask, net.IP{255, 255, 255, 0})
+		r.SetDuration(dhcp4.OptionAddressTime, time.Hour)
+	}
+
+	address := func(w dhcp4.ReplyWriter) net.IP {
+		return net.IP{10, 0, 0, byte(100 + w.Info().IfIndex)}
+	}
+
+	m := dhcp4.NewServeMux()
+	m.HandleFunc(dhcp4.MessageTypeDiscover, func(w dhcp4.ReplyWriter, p *dhcp4.Packet) {
+		offer := dhcp4.CreateOffer(p)
+		offer.SetYIAddr(address(w))
+		setOptions(&offer)
+		if err := w.WriteReply(&offer); err != nil {
+			t.Error(err)
+		}
+	})
+	m.HandleFunc(dhcp4.MessageTypeRequest, func(w dhcp4.ReplyWriter, p *dhcp4.Packet) {
+		ack := dhcp4.CreateAck(p)
+		ack.SetYIAddr(address(w))
+		setOptions(&ack)
+		if err := w.WriteReply(&ack); err != nil {
+			t.Error(err)
+		}
+	})
+
+	s := &dhcp4.Server{Handler: m}
+	go s.Serve(context.Background(), n.ServerConn())
+	t.Cleanup(func() { s.Shutdown(context.Background()) })
+}
+
+func TestDORA(t *testing.T) {
+	n := NewNetwork()
+	eth0 := n.AddInterface("eth0")
+	eth1 := n.AddInterface("eth1")
+	newTestServer(t, n)
+
+	for _, c := range []*Client{
+		eth0.NewClient(net.HardwareAddr{0, 0, 0, 0, 0, 1}),
+		eth1.NewClient(net.HardwareAddr{0, 0, 0, 0, 0, 2}),
+	} {
+		offer, ack, err := c.DORA(time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := net.IP{10, 0, 0, byte(100 + c.Interface.Index)}
+
+		AssertMessageType(t, offer.Packet, dhcp4.MessageTypeOffer)
+		AssertYIAddr(t, offer.Packet, expected)
+		AssertIPOption(t, offer.Packet, dhcp4.OptionDHCPServerID, serverID)
+
+		AssertMessageType(t, ack.Packet, dhcp4.MessageTypeAck)
+		AssertYIAddr(t, ack.Packet, expected)
+		AssertNoOption(t, ack.Packet, dhcp4.OptionAddressRequest)
+
+		// ServerConn can unicast to the hardware address of the client
+		AssertDst(t, ack, &net.UDPAddr{IP: expected, Port: dhcp4.ClientPort})
+		if ack.IfIndex != c.Interface.Index || !macEqual(ack.HardwareAddr, c.HardwareAddr) {
+			t.Errorf("ack delivered on %d to %s", ack.IfIndex, ack.HardwareAddr)
+		}
+	}
+}
+
+func TestReplySrc(t *testing.T) {
+	n := NewNetwork()
+	eth0 := n.AddInterface("eth0")
+	newTestServer(t, n)
+
+	// Broadcast replies are written from the server identifier
+	c := eth0.NewClient(net.HardwareAddr{0, 0, 0, 0, 0, 1})
+	discover := c.NewPacket(dhcp4.MessageTypeDiscover)
+	discover.Flags()[0] = 0x80
+	if err := c.Send(discover); err != nil {
+		t.Fatal(err)
+	}
+
+	offer, err := c.Receive(discover, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	AssertDst(t, offer, &net.UDPAddr{IP: net.IPv4bcast, Port: dhcp4.ClientPort})
+	if !offer.Src.Equal(serverID) {
+		t.Errorf("source: expected %s, got %s", serverID, offer.Src)
+	}
+}
+
+func TestServerID(t *testing.T) {
+	n := NewNetwork()
+	eth0 := n.AddInterface("eth0")
+	eth0.Addr = net.IP{10, 0, 1, 1}
+
+	h := dhcp4.HandlerFunc(func(w dhcp4.ReplyWriter, p *dhcp4.Packet) {
+		offer := dhcp4.CreateOffer(p)
+		offer.SetYIAddr(net.IP{10, 0, 1, 100})
+		offer.SetIP(dhcp4.OptionSubnetMask, net.IP{255, 255, 255, 0})
+		offer.SetDuration(dhcp4.OptionAddressTime, time.Hour)
+		if err := w.WriteReply(&offer); err != nil {
+			t.Error(err)
+		}
+	})
+
+	s := &dhcp4.Server{Handler: dhcp4.ServerIDHandler(h)}
+	go s.Serve(context.Background(), n.ServerConn())
+	defer s.Shutdown(context.Background())
+
+	c := eth0.NewClient(net.HardwareAddr{0, 0, 0, 0, 0, 1})
+	discover, err := c.SendDiscover()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	offer, err := c.Receive(discover, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertIPOption(t, offer.Packet, dhcp4.OptionDHCPServerID, eth0.Addr)
+}
+
+func TestReceiveTimeout(t *testing.T) {
+	n := NewNetwork()
+	c := n.AddInterface("eth0").NewClient(net.HardwareAddr{0, 0, 0, 0, 0, 1})
+
+	// Nobody answers
+	m := dhcp4.NewServeMux()
+	s := &dhcp4.Server{Handler: m}
+	go s.Serve(context.Background(), n.ServerConn())
+	defer s.Shutdown(context.Background())
+
+	discover, err := c.SendDiscover()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Receive(discover, 10*time.Millisecond); err != ErrTimeout {
+		t.Errorf("expected ErrTimeout, got %v", err)
+	}
+}
+
+func TestServerConnReadDeadline(t *testing.T) {
+	c := NewNetwork().ServerConn()
+
+	c.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	if _, _, _, err := c.ReadFrom(make([]byte, 1500)); !isTimeout(err) {
+		t.Errorf("expected timeout, got %v", err)
+	}
+
+	// A zero deadline makes the connection usable again
+	c.SetReadDeadline(time.Time{})
+	c.Close()
+	if _, _, _, err := c.ReadFrom(make([]byte, 1500)); err != net.ErrClosed {
+		t.Errorf("expected net.ErrClosed, got %v", err)
+	}
+}
+
+func isTimeout(err error) bool {
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
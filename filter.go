@@ -0,0 +1,100 @@
+package dhcp4
+
+// OptionPolicy defines whether an option is sent to a client that did not
+// list it in its Parameter Request List.
+type OptionPolicy int
+
+const (
+	// SendIfRequested sends the option only if the client requested it.
+	SendIfRequested = OptionPolicy(0)
+
+	// SendAlways sends the option even if the client did not request it.
+	//
+	// From RFC2131, section 4.3.1: The server MAY choose to return additional
+	// information in a DHCPOFFER message not explicitly requested by the
+	// client.
+	SendAlways = OptionPolicy(1)
+)
+
+// Options that are never removed from a reply, since they are part of the
+// protocol rather than configuration parameters, or since they must be echoed
+// regardless of the Parameter Request List. The renewal and rebinding times
+// belong with the lease time; clients rarely request them, and fall back to
+// their defaults if they are removed.
+var mandatoryReplyOptions = map[Option]bool{
+	OptionAddressTime:           true,
+	OptionRenewalTime:           true,
+	OptionRebindingTime:         true,
+	OptionOverload:              true,
+	OptionDHCPMsgType:           true,
+	OptionDHCPServerID:          true,
+	OptionDHCPMessage:           true,
+	OptionClientID:              true,
+	OptionRelayAgentInformation: true,
+}
+
+// ParameterFilter finalizes a reply by removing the options that the client
+// did not request. It does not need to order the remaining options; replies
+// created by CreateOffer, CreateAck and CreateNak always serialize the
+// requested options first, in the order of the Parameter Request List.
+//
+// The zero value removes every unrequested option that is not mandatory.
+type ParameterFilter struct {
+	// Default is the policy for options without an explicit policy.
+	Default OptionPolicy
+
+	policies map[Option]OptionPolicy
+}
+
+// NewParameterFilter returns a filter that sends the specified options even if
+// they are not requested.
+func NewParameterFilter(always ...Option) *ParameterFilter {
+	f := &ParameterFilter{}
+	for _, o := range always {
+		f.SetPolicy(o, SendAlways)
+	}
+	return f
+}
+
+// SetPolicy sets the policy for a single option.
+func (f *ParameterFilter) SetPolicy(o Option, p OptionPolicy) {
+	if f.policies == nil {
+		f.policies = make(map[Option]OptionPolicy)
+	}
+	f.policies[o] = p
+}
+
+// Policy returns the policy for an option.
+func (f *ParameterFilter) Policy(o Option) OptionPolicy {
+	if p, ok := f.policies[o]; ok {
+		return p
+	}
+	return f.Default
+}
+
+// Filter removes the options from the reply that the client did not request
+// and that are neither mandatory nor sent by policy. If the request has no
+// Parameter Request List, the reply is left untouched.
+func (f *ParameterFilter) Filter(r Reply) {
+	rep := r.Reply()
+	if rep == nil {
+		return
+	}
+
+	prl, ok := r.Message().GetParameterRequestList()
+	if !ok {
+		return
+	}
+
+	requested := make(map[Option]bool, len(prl))
+	for _, o := range prl {
+		requested[o] = true
+	}
+
+	for o := range rep.OptionMap {
+		if requested[o] || mandatoryReplyOptions[o] || f.Policy(o) == SendAlways {
+			continue
+		}
+		delete(rep.OptionMap, o)
+	}
+}
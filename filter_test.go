@@ -0,0 +1,124 @@
+package dhcp4
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newFilterTestOffer(prl []byte) Offer {
+	msg := NewPacket(BootRequest)
+	msg.SetMessageType(MessageTypeDiscover)
+	if prl != nil {
+		msg.SetOption(OptionParameterList, prl)
+	}
+
+	rep := CreateOffer(&msg)
+	rep.SetYIAddr(net.IP{10, 0, 0, 10})
+	rep.SetOption(OptionAddressTime, []byte{0, 0, 0, 60})
+	rep.SetOption(OptionDHCPServerID, []byte{10, 0, 0, 1})
+	rep.SetOption(OptionSubnetMask, []byte{255, 255, 255, 0})
+	rep.SetOption(OptionRouter, []byte{10, 0, 0, 1})
+	rep.SetOption(OptionDomainServer, []byte{10, 0, 0, 2})
+	rep.SetOption(OptionNTPServers, []byte{10, 0, 0, 3})
+	return rep
+}
+
+func TestParameterFilterRemovesUnrequested(t *testing.T) {
+	rep := newFilterTestOffer([]byte{byte(OptionRouter), byte(OptionSubnetMask)})
+
+	var f ParameterFilter
+	f.Filter(&rep)
+
+	assert.Equal(t, []Option{
+		OptionSubnetMask,
+		OptionRouter,
+		OptionAddressTime,
+		OptionDHCPMsgType,
+		OptionDHCPServerID,
+	}, rep.GetSortedOptions())
+}
+
+func TestParameterFilterKeepsRenewalTimes(t *testing.T) {
+	rep := newFilterTestOffer([]byte{byte(OptionRouter), byte(OptionSubnetMask)})
+	rep.SetDuration(OptionRenewalTime, 30*time.Second)
+	rep.SetDuration(OptionRebindingTime, 50*time.Second)
+
+	var f ParameterFilter
+	f.Filter(&rep)
+
+	assert.Equal(t, []Option{
+		OptionSubnetMask,
+		OptionRouter,
+		OptionAddressTime,
+		OptionDHCPMsgType,
+		OptionDHCPServerID,
+		OptionRenewalTime,
+		OptionRebindingTime,
+	}, rep.GetSortedOptions())
+}
+
+func TestParameterFilterPolicy(t *testing.T) {
+	rep := newFilterTestOffer([]byte{byte(OptionRouter)})
+
+	f := NewParameterFilter(OptionNTPServers)
+	f.Filter(&rep)
+
+	_, ok := rep.GetOption(OptionNTPServers)
+	assert.True(t, ok)
+	_, ok = rep.GetOption(OptionDomainServer)
+	assert.False(t, ok)
+
+	// Send everything by default, except what is explicitly filtered
+	rep = newFilterTestOffer([]byte{byte(OptionRouter)})
+
+	f = &ParameterFilter{Default: SendAlways}
+	f.SetPolicy(OptionDomainServer, SendIfRequested)
+	f.Filter(&rep)
+
+	_, ok = rep.GetOption(OptionNTPServers)
+	assert.True(t, ok)
+	_, ok = rep.GetOption(OptionDomainServer)
+	assert.False(t, ok)
+}
+
+func TestParameterFilterWithoutParameterRequestList(t *testing.T) {
+	rep := newFilterTestOffer(nil)
+	before := rep.GetSortedOptions()
+
+	var f ParameterFilter
+	f.Filter(&rep)
+
+	assert.Equal(t, before, rep.GetSortedOptions())
+}
+
+func TestReplyOptionsInRequestedOrder(t *testing.T) {
+	rep := newFilterTestOffer([]byte{
+		byte(OptionDomainServer),
+		byte(OptionHostname), // Not in the reply
+		byte(OptionRouter),
+		byte(OptionSubnetMask),
+	})
+
+	b, err := rep.ToBytes()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var actual []Option
+	for x := RawPacket(b).Options(); len(x) > 0 && Option(x[0]) != OptionEnd; x = x[2+int(x[1]):] {
+		actual = append(actual, Option(x[0]))
+	}
+
+	assert.Equal(t, []Option{
+		OptionDomainServer,
+		OptionRouter,
+		OptionSubnetMask,
+		OptionNTPServers,
+		OptionAddressTime,
+		OptionDHCPMsgType,
+		OptionDHCPServerID,
+	}, actual)
+}
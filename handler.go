@@ -1,7 +1,8 @@
 package dhcp4
 
 import (
-	"fmt"
+	"context"
+	"log/slog"
 	"net"
 
 	"golang.org/x/net/ipv4"
@@ -15,7 +16,8 @@ type PacketReader interface {
 
 // PacketWriter defines an adaptation of the WriteTo function (as defined
 // net.PacketConn) that includes the interface index the packet should be sent
-// on.
+// on. Like io.Writer, implementations must not retain b, which the server
+// reuses for other packets.
 type PacketWriter interface {
 	WriteTo(b []byte, addr net.Addr, ifindex int) (n int, err error)
 }
@@ -29,95 +31,245 @@ type PacketConn interface {
 	LocalAddr() net.Addr
 }
 
+// UDP ports used by DHCP servers and clients (RFC2131, section 4.1).
+const (
+	ServerPort = 67
+	ClientPort = 68
+)
+
+// Destination describes where a reply is sent to.
+type Destination struct {
+	Addr net.UDPAddr
+
+	// HardwareAddr is set when the reply is unicast to a client that doesn't
+	// have its address configured yet. Such a client cannot answer ARP
+	// requests, so the reply must be sent to this link-layer address directly.
+	HardwareAddr net.HardwareAddr
+}
+
+// ReplyDestination returns the destination of reply rep to request msg, which
+// was received from src.
+//
+// From RFC2131, section 4.1: If the 'giaddr' field in a DHCP message from a
+// client is non-zero, the server sends any return messages to the 'DHCP
+// server' port on the BOOTP relay agent whose address appears in 'giaddr'. If
+// the 'giaddr' field is zero and the 'ciaddr' field is nonzero, then the
+// server unicasts DHCPOFFER and DHCPACK messages to the address in 'ciaddr'.
+// If 'giaddr' is zero and 'ciaddr' is zero, and the broadcast bit is set,
+// then the server broadcasts DHCPOFFER and DHCPACK messages to 0xffffffff. If
+// the broadcast bit is not set and 'giaddr' is zero and 'ciaddr' is zero, then
+// the server unicasts DHCPOFFER and DHCPACK messages to the client's hardware
+// address and 'yiaddr' address. In all cases, when 'giaddr' is zero, the
+// server broadcasts any DHCPNAK messages to 0xffffffff.
+//
+// Replies to relay agents that include the Relay Source Port sub-option
+// (RFC8357) are sent to the source port of the request instead.
+func ReplyDestination(msg, rep *Packet, src *net.UDPAddr) Destination {
+	if ip := msg.GetGIAddr(); !ip.Equal(net.IPv4zero) {
+		port := ServerPort
+		if src != nil && src.Port != 0 && relaySourcePort(msg) {
+			port = src.Port
+		}
+		return Destination{Addr: net.UDPAddr{IP: ip, Port: port}}
+	}
+
+	bcast := Destination{Addr: net.UDPAddr{IP: net.IPv4bcast, Port: ClientPort}}
+	if rep != nil && rep.GetMessageType() == MessageTypeNak {
+		return bcast
+	}
+
+	if ip := msg.GetCIAddr(); !ip.Equal(net.IPv4zero) {
+		return Destination{Addr: net.UDPAddr{IP: ip, Port: ClientPort}}
+	}
+
+	if msg.GetFlags()[0]&0x80 > 0 || rep == nil || rep.GetYIAddr().Equal(net.IPv4zero) {
+		return bcast
+	}
+
+	return Destination{
+		Addr:         net.UDPAddr{IP: rep.GetYIAddr(), Port: ClientPort},
+		HardwareAddr: msg.GetCHAddr(),
+	}
+}
+
+// HardwareAddrWriter is implemented by PacketWriters that can send a packet to
+// an IP address at a given link-layer address, without relying on ARP.
+// Transports based on raw sockets implement it so that replies can be unicast
+// to clients that don't have an address configured yet. Replies that need
+// this are broadcast if the PacketWriter doesn't implement it. Like WriteTo,
+// WriteToHardwareAddr must not retain b.
+type HardwareAddrWriter interface {
+	WriteToHardwareAddr(b []byte, addr net.Addr, hw net.HardwareAddr, ifindex int) (n int, err error)
+}
+
+// SrcWriter is implemented by PacketWriters that can choose the source address
+// of the packets they write. Replies are written from their server identifier
+// (option 54), so that on interfaces with several addresses clients receive
+// them from the address they know the server by, and not from an address the
+// kernel picks.
+type SrcWriter interface {
+	// WriteToSrc is WriteTo with source address src. If src is nil, the
+	// kernel picks it.
+	WriteToSrc(b []byte, addr net.Addr, src net.IP, ifindex int) (n int, err error)
+}
+
+// DstReader is implemented by PacketReaders that report the local address
+// packets were sent to, which is set in RequestInfo.Dst.
+type DstReader interface {
+	// ReadFromDst is ReadFrom, also returning the destination address of the
+	// packet, or nil if it is unknown.
+	ReadFromDst(b []byte) (n int, addr net.Addr, ifindex int, dst net.IP, err error)
+}
+
+// readFromDst reads from pr with ReadFromDst, if it is a DstReader.
+func readFromDst(pr PacketReader, b []byte) (int, net.Addr, int, net.IP, error) {
+	if r, ok := pr.(DstReader); ok {
+		return r.ReadFromDst(b)
+	}
+
+	n, addr, ifindex, err := pr.ReadFrom(b)
+	return n, addr, ifindex, nil, err
+}
+
+// writeToSrc writes to pw with WriteToSrc, if it is a SrcWriter.
+func writeToSrc(pw PacketWriter, b []byte, addr net.Addr, src net.IP, ifindex int) (int, error) {
+	if w, ok := pw.(SrcWriter); ok && src != nil {
+		return w.WriteToSrc(b, addr, src, ifindex)
+	}
+	return pw.WriteTo(b, addr, ifindex)
+}
+
+// replySrc returns the address to write rep from: its server identifier, if
+// it has one.
+func replySrc(rep *Packet) net.IP {
+	ip, ok := rep.GetIP(OptionDHCPServerID)
+	if !ok || ip.Equal(net.IPv4zero) {
+		return nil
+	}
+	return ip
+}
+
 type replyWriter struct {
-	pw PacketWriter
+	pw   PacketWriter
+	info RequestInfo
+
+	// Set for requests that must not be answered
+	noReply bool
+
+	// Optional; nothing is logged or recorded if nil
+	logger  *slog.Logger
+	metrics Metrics
+}
 
-	// The client address, if any
-	addr    net.UDPAddr
-	ifindex int
+func (rw *replyWriter) Info() *RequestInfo {
+	return &rw.info
 }
 
 func (rw *replyWriter) WriteReply(r Reply) error {
+	err := rw.writeReply(r)
+	if err != nil && rw.logger != nil {
+		// ErrNoReply is expected for DHCPDECLINE and DHCPRELEASE
+		level := slog.LevelError
+		if err == ErrNoReply {
+			level = slog.LevelDebug
+		}
+		msg := r.Message()
+		rw.logger.Log(context.Background(), level, "failed to write reply",
+			"event", "error",
+			"mac", msg.GetCHAddr().String(),
+			"xid", formatHex(msg.XID()),
+			"err", err)
+	}
+	return err
+}
+
+func (rw *replyWriter) writeReply(r Reply) error {
+	if rw.noReply {
+		return ErrNoReply
+	}
+
 	if err := r.Validate(); err != nil {
+		if rw.metrics != nil {
+			for _, v := range violations(err) {
+				rw.metrics.ValidationFailed(v)
+			}
+		}
 		return err
 	}
 
-	bytes, err := r.ToBytes()
+	var bytes []byte
+	var err error
+	if a, ok := r.(ReplyAppender); ok {
+		buf := getBuffer()
+		defer func() { putBuffer(buf, bytes) }()
+		bytes, err = a.AppendTo((*buf)[:0])
+	} else {
+		bytes, err = r.ToBytes()
+	}
 	if err != nil {
 		return err
 	}
 
-	var (
-		msg  = r.Message()
-		addr = rw.addr
-	)
-	if ip := msg.GetGIAddr(); ip != nil && !ip.Equal(net.IPv4zero) {
-		addr.IP = ip
-	} else if addr.IP.Equal(net.IPv4zero) || msg.GetFlags()[0]&0x80 > 0 {
-		// Broadcast the reply if the request packet has no address associated with
-		// it, or if the client explicitly asks for a broadcast reply.
-		addr.IP = net.IPv4bcast
+	dst := ReplyDestination(r.Message(), r.Reply(), rw.info.Src)
+
+	if rw.logger != nil && rw.logger.Enabled(context.Background(), slog.LevelDebug) {
+		rw.logger.LogAttrs(context.Background(), slog.LevelDebug, "sending reply",
+			fieldsToAttrs(toFields("send", rw.info.IfIndex, dst.Addr.IP, r.Message(), r.Reply()))...)
+	}
+
+	if dst.HardwareAddr != nil {
+		if hw, ok := rw.pw.(HardwareAddrWriter); ok {
+			_, err = hw.WriteToHardwareAddr(bytes, &dst.Addr, dst.HardwareAddr, rw.info.IfIndex)
+			return rw.sent(r, err)
+		}
+
+		// From RFC2131, section 4.1: If unicasting is not possible, the message
+		// MAY be sent as an IP broadcast using an IP broadcast address
+		// (preferably 0xffffffff) as the IP destination address and the
+		// link-layer broadcast address as the link-layer destination address.
+		dst.Addr.IP = net.IPv4bcast
 	}
 
-	// dlog.With(toFields("send", rw.ifindex, addr.IP, msg, r.Reply())...).Debug()
+	_, err = writeToSrc(rw.pw, bytes, &dst.Addr, replySrc(r.Reply()), rw.info.IfIndex)
+	return rw.sent(r, err)
+}
 
-	_, err = rw.pw.WriteTo(bytes, &addr, rw.ifindex)
+// sent records the outcome of writing reply r to the network.
+func (rw *replyWriter) sent(r Reply, err error) error {
+	if rw.metrics == nil {
+		return err
+	}
+
+	if err != nil {
+		rw.metrics.WriteError()
+	} else if rep := r.Reply(); rep != nil {
+		rw.metrics.ReplySent(rep.GetMessageType())
+	}
 	return err
 }
 
-// FIXME(betawaffle)
 // Handler defines the interface an object needs to implement to handle DHCP
-// packets. The handler should do a type switch on the Message object that is
-// passed as argument to determine what kind of packet it is dealing with. It
-// can use the WriteReply function on the request to send a reply back to the
-// peer responsible for sending the request packet. While the handler may be
-// blocking, it is not encouraged. Rather, the handler should return as soon as
-// possible to avoid blocking the serve loop. If blocking operations need to be
-// executed to determine if the request packet needs a reply, and if so, what
-// kind of reply, it is recommended to handle this in separate goroutines. The
+// packets. The handler should check the message type of the packet that is
+// passed as argument to determine what kind of packet it is dealing with, or be
+// registered with a ServeMux that does this for it. It can use the WriteReply
+// function on the request to send a reply back to the peer responsible for
+// sending the request packet. The ReplyWriter is never nil, also for requests
+// that must not be answered, such as DHCPDECLINE and DHCPRELEASE; its
+// WriteReply function returns ErrNoReply for these. Unless the Server is
+// configured with Workers, the handler blocks the serve loop until it returns.
+// With Workers, handlers are called concurrently and may block, for example on
+// a lookup in an address management system, without delaying other clients. The
 // WriteReply function can be called from multiple goroutines without needing
 // extra synchronization.
 type Handler interface {
 	ServeDHCP(w ReplyWriter, p *Packet)
 }
 
-// Serve reads packets off the network and calls the specified handler.
+// Serve reads packets off the network and calls the specified handler. It
+// returns when reading from pc fails. Use a Server to be able to stop serving.
 func Serve(pc PacketConn, h Handler) error {
-	buf := make([]byte, 65536)
-	for {
-		n, addr, ifindex, err := pc.ReadFrom(buf)
-		if err != nil {
-			return err
-		}
-
-		p, err := PacketFromBytes(buf[:n])
-		if err != nil {
-			// dlog.With("error", err).Info()
-			fmt.Println("error", err)
-			continue
-		}
-
-		// Filter everything but requests
-		if op := OpCode(p.Op()[0]); op != BootRequest {
-			// dlog.With("op", op, "mac", p.GetCHAddr()).Info("ignoring")
-			continue
-		}
-
-		a := addr.(*net.UDPAddr)
-		// dlog.With(toFields("recv", ifindex, a.IP, &p, nil)...).Debug()
-
-		var rw ReplyWriter
-		switch p.GetMessageType() {
-		case MessageTypeDiscover, MessageTypeRequest, MessageTypeInform:
-			rw = &replyWriter{
-				pw: pc,
-
-				addr:    *a,
-				ifindex: ifindex,
-			}
-		}
-		h.ServeDHCP(rw, &p)
-	}
+	s := Server{Handler: h}
+	return s.Serve(context.Background(), pc)
 }
 
 func Listen(addr string) (PacketConn, error) {
@@ -137,16 +289,17 @@ func Listen(addr string) (PacketConn, error) {
 }
 
 func ListenAndServe(addr string, h Handler) error {
-	c, err := Listen(addr)
-	if err != nil {
-		return err
-	}
-	return Serve(c, h)
+	s := Server{Handler: h, Addr: addr}
+	return s.ListenAndServe(context.Background())
 }
 
 type packetConn struct {
 	net.PacketConn
 	ipv4pc *ipv4.PacketConn
+
+	// Messages reused by ReadBatch and WriteBatch
+	readBatch  batchMessages
+	writeBatch batchMessages
 }
 
 // NewPacketConn returns a PacketConn based on the specified net.PacketConn.
@@ -154,7 +307,7 @@ type packetConn struct {
 // and include the interface index argument in calls to WriteTo.
 func NewPacketConn(pc net.PacketConn) (PacketConn, error) {
 	ipv4pc := ipv4.NewPacketConn(pc)
-	if err := ipv4pc.SetControlMessage(ipv4.FlagInterface, true); err != nil {
+	if err := ipv4pc.SetControlMessage(ipv4.FlagInterface|ipv4.FlagDst, true); err != nil {
 		return nil, err
 	}
 
@@ -170,20 +323,54 @@ func NewPacketConn(pc net.PacketConn) (PacketConn, error) {
 // returns the network interface index the packet arrived on in addition to the
 // default return values of the ReadFrom function.
 func (p *packetConn) ReadFrom(b []byte) (int, net.Addr, int, error) {
+	n, src, ifindex, _, err := p.ReadFromDst(b)
+	return n, src, ifindex, err
+}
+
+// ReadFromDst is ReadFrom, also returning the destination address of the
+// packet.
+func (p *packetConn) ReadFromDst(b []byte) (int, net.Addr, int, net.IP, error) {
 	n, cm, src, err := p.ipv4pc.ReadFrom(b)
 	if err != nil {
-		return n, src, -1, err
+		return n, src, -1, nil, err
 	}
+	if cm == nil {
+		return n, src, -1, nil, nil
+	}
+
+	return n, src, cm.IfIndex, cm.Dst, nil
+}
 
-	return n, src, cm.IfIndex, err
+// LookupInterface returns the name and the primary IPv4 address of the
+// interface of the host with index ifindex.
+func (p *packetConn) LookupInterface(ifindex int) (string, net.IP, bool) {
+	ifi, ok := hostInterfaces.lookup(ifindex)
+	if !ok {
+		return "", nil, false
+	}
+	return ifi.name, ifi.addr(), true
 }
 
 // WriteTo writes a packet with payload b to addr. It explicitly sends the
 // packet over the network interface  with the specified index.
 func (p *packetConn) WriteTo(b []byte, addr net.Addr, ifindex int) (int, error) {
+	return p.WriteToSrc(b, addr, nil, ifindex)
+}
+
+// WriteToSrc is WriteTo with source address src. If src is not an address of
+// the host, the packet is written again with a source address the kernel
+// picks, so that a misconfigured server identifier doesn't keep replies from
+// being sent.
+func (p *packetConn) WriteToSrc(b []byte, addr net.Addr, src net.IP, ifindex int) (int, error) {
 	cm := &ipv4.ControlMessage{
 		IfIndex: ifindex,
+		Src:     src,
 	}
 
-	return p.ipv4pc.WriteTo(b, cm, addr)
+	n, err := p.ipv4pc.WriteTo(b, cm, addr)
+	if err != nil && src != nil {
+		cm.Src = nil
+		return p.ipv4pc.WriteTo(b, cm, addr)
+	}
+	return n, err
 }
@@ -4,29 +4,13 @@ import (
 	"errors"
 	"io"
 	"net"
-	"os"
 	"testing"
+	"time"
 
-	"github.com/packethost/pkg/log"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
 
-func TestMain(m *testing.M) {
-	os.Setenv("PACKET_ENV", "test")
-	os.Setenv("PACKET_VERSION", "0")
-	os.Setenv("ROLLBAR_DISABLE", "1")
-	os.Setenv("ROLLBAR_TOKEN", "1")
-
-	log, cleanup, err := log.Init("testing")
-	if err != nil {
-		panic(err)
-	}
-	defer cleanup()
-	Init(log)
-	os.Exit(m.Run())
-}
-
 type testPacketConn struct {
 	mock.Mock
 }
@@ -86,6 +70,8 @@ type testReply struct {
 
 	// Embed OptionMap so this struct implements the Reply interface.
 	OptionMap
+
+	reply *Packet
 }
 
 func (r *testReply) Validate() error {
@@ -115,7 +101,7 @@ func (r *testReply) Message() *Packet {
 }
 
 func (r *testReply) Reply() *Packet {
-	return nil // FIXME
+	return r.reply
 }
 
 func (r *testReply) SetCIAddr(ip net.IP) {}
@@ -153,31 +139,53 @@ func TestReplyWriterReturnsSerializationError(t *testing.T) {
 }
 
 func TestReplyWriterDestinationAddress(t *testing.T) {
-	withBcast := NewPacket(BootRequest)
-	withBcast.Flags()[0] |= 128 // Set MSB
-
-	withoutBcast := NewPacket(BootRequest)
-	withoutBcast.Flags()[0] &= 127 // Clear MSB
-
 	zeroIP := net.IP{0, 0, 0, 0}
 	someIP := net.IP{1, 2, 3, 4}
+	relayIP := net.IP{10, 0, 0, 254}
+	yourIP := net.IP{10, 0, 0, 10}
+
+	newMsg := func(bcast bool, ciaddr, giaddr net.IP) *Packet {
+		p := NewPacket(BootRequest)
+		if bcast {
+			p.Flags()[0] |= 128 // Set MSB
+		}
+		p.SetCIAddr(ciaddr)
+		p.SetGIAddr(giaddr)
+		return &p
+	}
+
+	newReply := func(t MessageType, yiaddr net.IP) *Packet {
+		p := NewPacket(BootReply)
+		p.SetMessageType(t)
+		p.SetYIAddr(yiaddr)
+		return &p
+	}
 
 	testCases := []struct {
 		msg *Packet
-		src net.UDPAddr
-		dst net.IP
+		rep *Packet
+		dst net.UDPAddr
 	}{
-		// Broadcast flag trumps everything
-		{&withBcast, net.UDPAddr{IP: zeroIP}, net.IPv4bcast},
-		{&withBcast, net.UDPAddr{IP: someIP}, net.IPv4bcast},
+		// Relay agent trumps everything
+		{newMsg(true, someIP, relayIP), newReply(MessageTypeAck, yourIP), net.UDPAddr{IP: relayIP, Port: 67}},
+		{newMsg(false, zeroIP, relayIP), newReply(MessageTypeNak, zeroIP), net.UDPAddr{IP: relayIP, Port: 67}},
 
-		// Without broadcast flag, only broadcast without a destination IP
-		{&withoutBcast, net.UDPAddr{IP: zeroIP}, net.IPv4bcast},
-		{&withoutBcast, net.UDPAddr{IP: someIP}, someIP},
+		// NAK is broadcast without relay agent
+		{newMsg(false, someIP, zeroIP), newReply(MessageTypeNak, zeroIP), net.UDPAddr{IP: net.IPv4bcast, Port: 68}},
+
+		// Unicast to ciaddr, regardless of broadcast flag
+		{newMsg(false, someIP, zeroIP), newReply(MessageTypeAck, someIP), net.UDPAddr{IP: someIP, Port: 68}},
+		{newMsg(true, someIP, zeroIP), newReply(MessageTypeAck, someIP), net.UDPAddr{IP: someIP, Port: 68}},
+
+		// Broadcast flag
+		{newMsg(true, zeroIP, zeroIP), newReply(MessageTypeOffer, yourIP), net.UDPAddr{IP: net.IPv4bcast, Port: 68}},
+
+		// Unicast to yiaddr is not possible without HardwareAddrWriter
+		{newMsg(false, zeroIP, zeroIP), newReply(MessageTypeOffer, yourIP), net.UDPAddr{IP: net.IPv4bcast, Port: 68}},
 	}
 
 	for _, testCase := range testCases {
-		r := testReply{}
+		r := testReply{reply: testCase.rep}
 		r.On("Validate").Return(nil)
 		r.On("ToBytes").Return([]byte("xyz"), nil)
 		r.On("Message").Return(testCase.msg)
@@ -186,16 +194,57 @@ func TestReplyWriterDestinationAddress(t *testing.T) {
 		pw.On("WriteTo", mock.Anything, mock.Anything, mock.Anything).Return(3, nil)
 
 		rw := replyWriter{
-			pw:   pw,
-			addr: testCase.src,
+			pw: pw,
 		}
 
 		err := rw.WriteReply(&r)
 		assert.NoError(t, err)
 
-		expected := net.UDPAddr{IP: testCase.dst}
 		actual := *pw.Calls[0].Arguments[1].(*net.UDPAddr)
-		assert.Equal(t, expected, actual)
+		assert.Equal(t, testCase.dst, actual)
+	}
+}
+
+type testHardwareAddrConn struct {
+	testPacketConn
+}
+
+func (pc *testHardwareAddrConn) WriteToHardwareAddr(b []byte, addr net.Addr, hw net.HardwareAddr, ifindex int) (n int, err error) {
+	args := pc.Called(b, addr, hw, ifindex)
+	return args.Int(0), args.Error(1)
+}
+
+func TestReplyWriterHardwareAddrUnicast(t *testing.T) {
+	mac := net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+
+	msg := NewPacket(BootRequest)
+	msg.HLen()[0] = 6
+	copy(msg.CHAddr(), mac)
+
+	rep := NewReply(&msg)
+	rep.SetMessageType(MessageTypeOffer)
+	rep.SetYIAddr(net.IP{10, 0, 0, 10})
+
+	r := testReply{reply: &rep}
+	r.On("Validate").Return(nil)
+	r.On("ToBytes").Return([]byte("xyz"), nil)
+	r.On("Message").Return(&msg)
+
+	pw := &testHardwareAddrConn{}
+	pw.On("WriteToHardwareAddr", mock.Anything, mock.Anything, mock.Anything, 3).Return(3, nil)
+
+	rw := replyWriter{
+		pw:   pw,
+		info: RequestInfo{IfIndex: 3},
+	}
+
+	err := rw.WriteReply(&r)
+	assert.NoError(t, err)
+
+	pw.AssertNotCalled(t, "WriteTo", mock.Anything, mock.Anything, mock.Anything)
+	if assert.Len(t, pw.Calls, 1) {
+		assert.Equal(t, net.UDPAddr{IP: net.IP{10, 0, 0, 10}, Port: 68}, *pw.Calls[0].Arguments[1].(*net.UDPAddr))
+		assert.Equal(t, mac, pw.Calls[0].Arguments[2])
 	}
 }
 
@@ -207,6 +256,90 @@ func (h *testHandler) ServeDHCP(w ReplyWriter, p *Packet) {
 	h.Called(w, p)
 }
 
+type testSrcConn struct {
+	testPacketConn
+}
+
+func (pc *testSrcConn) WriteToSrc(b []byte, addr net.Addr, src net.IP, ifindex int) (n int, err error) {
+	args := pc.Called(b, addr, src, ifindex)
+	return args.Int(0), args.Error(1)
+}
+
+func TestReplyWriterSrc(t *testing.T) {
+	serverID := net.IP{10, 0, 0, 1}
+
+	msg := NewPacket(BootRequest)
+	msg.Flags()[0] = 0x80
+
+	rep := NewReply(&msg)
+	rep.SetMessageType(MessageTypeOffer)
+	rep.SetIP(OptionDHCPServerID, serverID)
+
+	r := testReply{reply: &rep}
+	r.On("Validate").Return(nil)
+	r.On("ToBytes").Return([]byte("xyz"), nil)
+	r.On("Message").Return(&msg)
+
+	pw := &testSrcConn{}
+	pw.On("WriteToSrc", mock.Anything, mock.Anything, mock.Anything, 3).Return(3, nil)
+
+	rw := replyWriter{
+		pw:   pw,
+		info: RequestInfo{IfIndex: 3},
+	}
+
+	err := rw.WriteReply(&r)
+	assert.NoError(t, err)
+
+	pw.AssertNotCalled(t, "WriteTo", mock.Anything, mock.Anything, mock.Anything)
+	if assert.Len(t, pw.Calls, 1) {
+		assert.True(t, serverID.Equal(pw.Calls[0].Arguments[2].(net.IP)))
+	}
+}
+
+func TestPacketConnSrcDst(t *testing.T) {
+	pc, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer pc.Close()
+
+	client, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// The destination address of requests is reported
+	client.WriteTo([]byte("request"), pc.(*packetConn).LocalAddr())
+
+	b := make([]byte, 16)
+	_, _, _, dst, err := pc.(DstReader).ReadFromDst(b)
+	if assert.NoError(t, err) {
+		assert.True(t, dst.Equal(net.IPv4(127, 0, 0, 1)), "dst %s", dst)
+	}
+
+	// Replies are written from the source address, if it is an address of
+	// the host, and from an address the kernel picks otherwise
+	for _, tc := range []struct {
+		src, expected net.IP
+	}{
+		{net.IPv4(127, 0, 0, 5), net.IPv4(127, 0, 0, 5)},
+		{net.IPv4(192, 0, 2, 1), net.IPv4(127, 0, 0, 1)},
+	} {
+		_, err := pc.(SrcWriter).WriteToSrc([]byte("reply"), client.LocalAddr(), tc.src, 0)
+		if !assert.NoError(t, err) {
+			continue
+		}
+
+		_, addr, err := client.ReadFrom(b)
+		if assert.NoError(t, err) {
+			assert.True(t, addr.(*net.UDPAddr).IP.Equal(tc.expected), "src %s", addr)
+		}
+	}
+}
+
 func TestServeReturnsReadError(t *testing.T) {
 	pc := &testPacketConn{}
 	pc.ReadError(io.EOF)
@@ -0,0 +1,497 @@
+package dhcp4
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// errBindToDeviceUnsupported is returned by listenDevice on platforms without
+// SO_BINDTODEVICE.
+var errBindToDeviceUnsupported = errors.New("dhcp4: binding to a device is not supported")
+
+// errReusePortUnsupported is returned by listenReusePort on platforms without
+// SO_REUSEPORT.
+var errReusePortUnsupported = errors.New("dhcp4: SO_REUSEPORT is not supported")
+
+// InterfaceFilter selects network interfaces by name. Patterns use the syntax
+// of path.Match, for example "eth*" or "vlan10?".
+type InterfaceFilter struct {
+	// Allow lists the interfaces to serve. If empty, all interfaces are
+	// allowed.
+	Allow []string
+
+	// Deny lists the interfaces not to serve, even if they are allowed.
+	Deny []string
+}
+
+// Match returns whether the interface with the specified name is allowed and
+// not denied.
+func (f InterfaceFilter) Match(name string) bool {
+	for _, pattern := range f.Deny {
+		if ok, _ := path.Match(pattern, name); ok {
+			return false
+		}
+	}
+
+	if len(f.Allow) == 0 {
+		return true
+	}
+
+	for _, pattern := range f.Allow {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// interfaceIPv4 returns the primary IPv4 address of an interface, which is the
+// first one the system lists.
+func interfaceIPv4(ifi *net.Interface) net.IP {
+	if nets := interfaceNets(ifi); len(nets) > 0 {
+		return nets[0].IP
+	}
+	return nil
+}
+
+// How long the interfaces of the host are cached for, unless they are watched
+// by an InterfaceWatcher.
+const interfaceTableTTL = 5 * time.Second
+
+// interfaceTable caches the interfaces of the host and their IPv4 networks, so
+// that requests can be mapped to interfaces without system calls. Lookups
+// don't take a lock: the table is rebuilt aside and swapped in, and lookups
+// keep using the previous table while it is being rebuilt.
+type interfaceTable struct {
+	current atomic.Pointer[interfaceSnapshot]
+
+	// Held while the interfaces are listed
+	refreshing sync.Mutex
+
+	// Incremented when the interfaces change; tables listed before are stale
+	gen atomic.Uint64
+
+	// While interfaces are watched, the table is only refreshed after they
+	// change
+	watchers atomic.Int32
+}
+
+// interfaceSnapshot is the list of interfaces at a point in time.
+type interfaceSnapshot struct {
+	ifaces  map[int]hostInterface
+	updated time.Time
+	gen     uint64
+}
+
+type hostInterface struct {
+	name string
+
+	// IPv4 networks of the interface, the primary one first
+	nets []*net.IPNet
+}
+
+// addr returns the primary IPv4 address of the interface.
+func (i hostInterface) addr() net.IP {
+	if len(i.nets) > 0 {
+		return i.nets[0].IP
+	}
+	return nil
+}
+
+// hostInterfaces is the interface table of the host.
+var hostInterfaces = &interfaceTable{}
+
+// lookup returns the interface with index ifindex.
+func (t *interfaceTable) lookup(ifindex int) (hostInterface, bool) {
+	ifi, ok := t.snapshot().ifaces[ifindex]
+	return ifi, ok
+}
+
+// subnetAddr returns the address of the host on the IPv4 network that ip
+// belongs to, or nil if the host isn't on that network.
+func (t *interfaceTable) subnetAddr(ip net.IP) net.IP {
+	for _, ifi := range t.snapshot().ifaces {
+		for _, n := range ifi.nets {
+			if n.Contains(ip) {
+				return n.IP
+			}
+		}
+	}
+	return nil
+}
+
+// isBroadcast returns whether ip is the broadcast address of one of the IPv4
+// networks of the host, that is, a subnet-directed broadcast address.
+func (t *interfaceTable) isBroadcast(ip net.IP) bool {
+	ip = ip.To4()
+	if ip == nil {
+		return false
+	}
+
+	for _, ifi := range t.snapshot().ifaces {
+		for _, n := range ifi.nets {
+			if b := broadcastAddr(n); b != nil && b.Equal(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// broadcastAddr returns the broadcast address of IPv4 network n, or nil if it
+// has none, as is the case for /31 and /32 networks (RFC3021).
+func broadcastAddr(n *net.IPNet) net.IP {
+	ip, mask := n.IP.To4(), n.Mask
+	if len(mask) == net.IPv6len {
+		mask = mask[12:]
+	}
+	if ones, bits := mask.Size(); ip == nil || bits != 8*net.IPv4len || ones >= 31 {
+		return nil
+	}
+
+	b := make(net.IP, net.IPv4len)
+	for i := range b {
+		b[i] = ip[i] | ^mask[i]
+	}
+	return b
+}
+
+// hasAddr returns whether ip is an IPv4 address of the host.
+func (t *interfaceTable) hasAddr(ip net.IP) bool {
+	for _, ifi := range t.snapshot().ifaces {
+		for _, n := range ifi.nets {
+			if n.IP.Equal(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// watch marks the interfaces as watched, or not watched anymore if delta is
+// negative.
+func (t *interfaceTable) watch(delta int) {
+	t.watchers.Add(int32(delta))
+	t.gen.Add(1)
+}
+
+// invalidate makes the next lookup list the interfaces of the host again.
+func (t *interfaceTable) invalidate() {
+	t.gen.Add(1)
+}
+
+// expired returns whether the interfaces must be listed again.
+func (t *interfaceTable) expired(s *interfaceSnapshot) bool {
+	if s.gen != t.gen.Load() {
+		return true
+	}
+	return t.watchers.Load() <= 0 && time.Since(s.updated) >= interfaceTableTTL
+}
+
+// snapshot returns the current table, listing the interfaces of the host
+// again if it expired. While another goroutine lists them, the expired table
+// is returned rather than waiting. It never returns nil.
+func (t *interfaceTable) snapshot() *interfaceSnapshot {
+	s := t.current.Load()
+	if s != nil && !t.expired(s) {
+		return s
+	}
+
+	if s == nil {
+		t.refreshing.Lock()
+	} else if !t.refreshing.TryLock() {
+		return s
+	}
+	defer t.refreshing.Unlock()
+
+	// Another goroutine may have listed them in the meantime
+	if s = t.current.Load(); s != nil && !t.expired(s) {
+		return s
+	}
+
+	gen := t.gen.Load()
+	ifis, err := net.Interfaces()
+	if err != nil {
+		if s == nil {
+			return &interfaceSnapshot{}
+		}
+		return s
+	}
+
+	s = &interfaceSnapshot{
+		ifaces:  make(map[int]hostInterface, len(ifis)),
+		updated: time.Now(),
+		gen:     gen,
+	}
+	for _, ifi := range ifis {
+		s.ifaces[ifi.Index] = hostInterface{name: ifi.Name, nets: interfaceNets(&ifi)}
+	}
+	t.current.Store(s)
+	return s
+}
+
+// interfaceNets returns the IPv4 networks of an interface, in the order the
+// system lists them.
+func interfaceNets(ifi *net.Interface) []*net.IPNet {
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil
+	}
+
+	var nets []*net.IPNet
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok {
+			if ip := n.IP.To4(); ip != nil {
+				nets = append(nets, &net.IPNet{IP: ip, Mask: n.Mask[len(n.Mask)-net.IPv4len:]})
+			}
+		}
+	}
+	return nets
+}
+
+// interfaceInfo holds what the server knows about the interface a request
+// arrived on.
+type interfaceInfo struct {
+	name string
+	addr net.IP
+}
+
+// current returns info with the current address of the interface, which
+// changes when the interface is reconfigured.
+func (info interfaceInfo) current(ifindex int) interfaceInfo {
+	if ifi, ok := hostInterfaces.lookup(ifindex); ok && ifi.name == info.name {
+		info.addr = ifi.addr()
+	}
+	return info
+}
+
+// InterfaceLookup is implemented by PacketConns that know the interfaces they
+// receive packets on, such as the ones returned by Listen and ListenInterface.
+// The server uses it to set RequestInfo.IfName and RequestInfo.IfAddr.
+type InterfaceLookup interface {
+	// LookupInterface returns the name and the primary IPv4 address of the
+	// interface with index ifindex. It returns false if the interface is
+	// unknown.
+	LookupInterface(ifindex int) (name string, addr net.IP, ok bool)
+}
+
+var errNoReadDeadline = errors.New("dhcp4: read deadlines not supported")
+
+// setReadDeadline sets the read deadline of pc, if it supports read deadlines.
+// PacketConns wrapping another PacketConn use it to let the server stop
+// reading from them without closing them.
+func setReadDeadline(pc PacketConn, t time.Time) error {
+	if d, ok := pc.(readDeadliner); ok {
+		return d.SetReadDeadline(t)
+	}
+	return errNoReadDeadline
+}
+
+// interfaceConn is a PacketConn that only receives packets from a single
+// interface.
+type interfaceConn struct {
+	PacketConn
+	index int
+	info  interfaceInfo
+}
+
+func (c *interfaceConn) LookupInterface(ifindex int) (string, net.IP, bool) {
+	if ifindex != c.index {
+		return "", nil, false
+	}
+
+	info := c.info.current(ifindex)
+	return info.name, info.addr, true
+}
+
+func (c *interfaceConn) SetReadDeadline(t time.Time) error {
+	return setReadDeadline(c.PacketConn, t)
+}
+
+func (c *interfaceConn) ReadFromDst(b []byte) (int, net.Addr, int, net.IP, error) {
+	return readFromDst(c.PacketConn, b)
+}
+
+func (c *interfaceConn) WriteToSrc(b []byte, addr net.Addr, src net.IP, ifindex int) (int, error) {
+	return writeToSrc(c.PacketConn, b, addr, src, ifindex)
+}
+
+// ListenInterface returns a PacketConn listening on the DHCP server port that
+// only receives packets from the specified interface. It binds the socket to
+// the interface with SO_BINDTODEVICE, which is only supported on Linux, so
+// that every interface can have its own socket.
+//
+// Requests read from the returned PacketConn carry the name and the primary
+// IPv4 address of the interface in their RequestInfo.
+func ListenInterface(ifi *net.Interface) (PacketConn, error) {
+	l, err := listenDevice(ifi.Name, fmt.Sprintf(":%d", ServerPort))
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := NewPacketConn(l)
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	return &interfaceConn{
+		PacketConn: c,
+		index:      ifi.Index,
+		info:       interfaceInfo{name: ifi.Name, addr: interfaceIPv4(ifi)},
+	}, nil
+}
+
+// filterConn is a PacketConn that drops packets from interfaces it doesn't
+// know. It is used where sockets cannot be bound to an interface.
+type filterConn struct {
+	PacketConn
+	ifaces map[int]interfaceInfo
+}
+
+func (c *filterConn) LookupInterface(ifindex int) (string, net.IP, bool) {
+	info, ok := c.ifaces[ifindex]
+	if !ok {
+		return "", nil, false
+	}
+
+	info = info.current(ifindex)
+	return info.name, info.addr, true
+}
+
+func (c *filterConn) SetReadDeadline(t time.Time) error {
+	return setReadDeadline(c.PacketConn, t)
+}
+
+func (c *filterConn) ReadFrom(b []byte) (int, net.Addr, int, error) {
+	n, addr, ifindex, _, err := c.ReadFromDst(b)
+	return n, addr, ifindex, err
+}
+
+func (c *filterConn) ReadFromDst(b []byte) (int, net.Addr, int, net.IP, error) {
+	for {
+		n, addr, ifindex, dst, err := readFromDst(c.PacketConn, b)
+		if err != nil {
+			return n, addr, ifindex, dst, err
+		}
+		if _, ok := c.ifaces[ifindex]; ok {
+			return n, addr, ifindex, dst, nil
+		}
+	}
+}
+
+func (c *filterConn) WriteToSrc(b []byte, addr net.Addr, src net.IP, ifindex int) (int, error) {
+	return writeToSrc(c.PacketConn, b, addr, src, ifindex)
+}
+
+// newFilterConn returns a PacketConn that only receives packets from the
+// specified interfaces.
+func newFilterConn(pc PacketConn, ifis []net.Interface) *filterConn {
+	c := &filterConn{
+		PacketConn: pc,
+		ifaces:     make(map[int]interfaceInfo, len(ifis)),
+	}
+	for i := range ifis {
+		c.ifaces[ifis[i].Index] = interfaceInfo{name: ifis[i].Name, addr: interfaceIPv4(&ifis[i])}
+	}
+	return c
+}
+
+// matchInterfaces returns the interfaces that are up, are not loopback
+// interfaces, and are matched by f.
+func matchInterfaces(f InterfaceFilter) ([]net.Interface, error) {
+	all, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	var ifis []net.Interface
+	for _, ifi := range all {
+		if ifi.Flags&net.FlagUp == 0 || ifi.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		if f.Match(ifi.Name) {
+			ifis = append(ifis, ifi)
+		}
+	}
+	return ifis, nil
+}
+
+// ListenAndServeInterfaces serves the interfaces matched by f that are up and
+// are not loopback interfaces, all with the same Handler. Every interface
+// gets its own socket, bound with ListenInterface. Where that is not
+// supported, a single socket listening on s.Addr is shared by all interfaces,
+// and packets from other interfaces are dropped.
+//
+// It returns when serving any of the interfaces fails, after the other
+// interfaces have stopped being served. The connections are closed when it
+// returns.
+func (s *Server) ListenAndServeInterfaces(ctx context.Context, f InterfaceFilter) error {
+	ifis, err := matchInterfaces(f)
+	if err != nil {
+		return err
+	}
+	if len(ifis) == 0 {
+		return errors.New("dhcp4: no interfaces to serve")
+	}
+
+	var conns []PacketConn
+	for i := range ifis {
+		c, err := ListenInterface(&ifis[i])
+		if err == errBindToDeviceUnsupported {
+			conns = nil
+			break
+		}
+		if err != nil {
+			for _, c := range conns {
+				c.Close()
+			}
+			return err
+		}
+		conns = append(conns, c)
+	}
+
+	if conns == nil {
+		c, err := Listen(s.Addr)
+		if err != nil {
+			return err
+		}
+		conns = []PacketConn{newFilterConn(c, ifis)}
+	}
+
+	return s.serveAll(ctx, conns)
+}
+
+// serveAll serves all connections until serving any of them fails, and closes
+// them.
+func (s *Server) serveAll(ctx context.Context, conns []PacketConn) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errc := make(chan error, len(conns))
+	var wg sync.WaitGroup
+	for _, c := range conns {
+		wg.Add(1)
+		go func(c PacketConn) {
+			defer wg.Done()
+			errc <- s.Serve(ctx, c)
+		}(c)
+	}
+
+	// The first error stops the others
+	err := <-errc
+	cancel()
+	wg.Wait()
+
+	for _, c := range conns {
+		c.Close()
+	}
+	return err
+}
@@ -0,0 +1,125 @@
+package dhcp4
+
+import (
+	"context"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInterfaceFilterMatch(t *testing.T) {
+	testCases := []struct {
+		f        InterfaceFilter
+		name     string
+		expected bool
+	}{
+		{InterfaceFilter{}, "eth0", true},
+		{InterfaceFilter{Allow: []string{"eth*"}}, "eth0", true},
+		{InterfaceFilter{Allow: []string{"eth*"}}, "wlan0", false},
+		{InterfaceFilter{Deny: []string{"docker*"}}, "docker0", false},
+		{InterfaceFilter{Deny: []string{"docker*"}}, "eth0", true},
+		{InterfaceFilter{Allow: []string{"eth*"}, Deny: []string{"eth1"}}, "eth1", false},
+		{InterfaceFilter{Allow: []string{"eth*"}, Deny: []string{"eth1"}}, "eth2", true},
+	}
+
+	for _, testCase := range testCases {
+		assert.Equal(t, testCase.expected, testCase.f.Match(testCase.name), "%+v %s", testCase.f, testCase.name)
+	}
+}
+
+// ifindexPacketConn is a chanPacketConn that reports packets as received on
+// the interface with index ifindex of the next packet.
+type ifindexPacketConn struct {
+	*chanPacketConn
+	ifindex chan int
+}
+
+func (pc *ifindexPacketConn) ReadFrom(b []byte) (int, net.Addr, int, error) {
+	n, addr, _, err := pc.chanPacketConn.ReadFrom(b)
+	if err != nil {
+		return n, addr, -1, err
+	}
+	return n, addr, <-pc.ifindex, nil
+}
+
+func TestInterfaceTableBroadcast(t *testing.T) {
+	table := &interfaceTable{}
+	table.current.Store(&interfaceSnapshot{
+		ifaces: map[int]hostInterface{
+			1: {name: "eth0", nets: []*net.IPNet{
+				{IP: net.IP{10, 0, 0, 1}, Mask: net.CIDRMask(24, 32)},
+				{IP: net.IP{192, 168, 0, 1}, Mask: net.CIDRMask(31, 32)},
+			}},
+		},
+		updated: time.Now(),
+	})
+
+	assert.True(t, table.isBroadcast(net.IP{10, 0, 0, 255}))
+	assert.True(t, table.isBroadcast(net.IPv4(10, 0, 0, 255)))
+	assert.False(t, table.isBroadcast(net.IP{10, 0, 0, 1}))
+	assert.False(t, table.isBroadcast(net.IP{10, 0, 1, 255}))
+
+	// Both addresses of a /31 network are host addresses
+	assert.False(t, table.isBroadcast(net.IP{192, 168, 0, 1}))
+	assert.False(t, table.isBroadcast(nil))
+}
+
+func TestFilterConn(t *testing.T) {
+	pc := &ifindexPacketConn{
+		chanPacketConn: newChanPacketConn(),
+		ifindex:        make(chan int, 2),
+	}
+
+	c := newFilterConn(pc, []net.Interface{{Index: 2, Name: "eth1"}})
+
+	infos := make(chan RequestInfo, 2)
+	s := Server{
+		Handler: HandlerFunc(func(w ReplyWriter, p *Packet) {
+			infos <- *w.Info()
+		}),
+	}
+
+	errc := make(chan error)
+	go func() { errc <- s.Serve(context.Background(), c) }()
+
+	pc.ifindex <- 1
+	pc.in <- newTestDiscover(t)
+	pc.ifindex <- 2
+	pc.in <- newTestDiscover(t)
+
+	// Only the packet from eth1 is handled
+	info := <-infos
+	assert.Equal(t, 2, info.IfIndex)
+	assert.Equal(t, "eth1", info.IfName)
+	assert.Empty(t, infos)
+
+	// The read deadline of the wrapped connection is used
+	assert.NoError(t, s.Shutdown(context.Background()))
+	assert.Equal(t, ErrServerClosed, <-errc)
+}
+
+func TestListenDevice(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("SO_BINDTODEVICE is only supported on Linux")
+	}
+
+	lo, err := net.InterfaceByIndex(1)
+	if err != nil || lo.Flags&net.FlagLoopback == 0 {
+		t.Skip("no loopback interface")
+	}
+
+	// Two sockets on the same port, bound to the same device
+	c1, err := listenDevice(lo.Name, "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot bind to device: %v", err)
+	}
+	defer c1.Close()
+
+	c2, err := listenDevice(lo.Name, c1.LocalAddr().String())
+	if assert.NoError(t, err) {
+		c2.Close()
+	}
+}
@@ -0,0 +1,117 @@
+package dhcp4
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+var (
+	// ErrLeaseNotFound is returned by a LeaseStore when there is no lease
+	// in force for an address or client.
+	ErrLeaseNotFound = errors.New("dhcp4: lease not found")
+
+	// ErrLeaseConflict is returned by a LeaseStore when a lease changed since
+	// it was read, or when creating a lease would take an address or client
+	// that already has one.
+	ErrLeaseConflict = errors.New("dhcp4: lease conflict")
+
+	// ErrInvalidLease is returned by a LeaseStore for leases without an IPv4
+	// address or expiry time.
+	ErrInvalidLease = errors.New("dhcp4: invalid lease")
+)
+
+// LeaseState is the state of a lease.
+type LeaseState int
+
+const (
+	// LeaseOffered is the state of an address offered in a DHCPOFFER, which
+	// the client did not request yet.
+	LeaseOffered = LeaseState(1)
+
+	// LeaseBound is the state of an address acknowledged in a DHCPACK.
+	LeaseBound = LeaseState(2)
+
+	// LeaseDeclined is the state of an address a client declined because it
+	// is already in use. It is not bound to a client.
+	LeaseDeclined = LeaseState(3)
+)
+
+var leaseStateStrings = map[LeaseState]string{
+	LeaseOffered:  "offered",
+	LeaseBound:    "bound",
+	LeaseDeclined: "declined",
+}
+
+func (s LeaseState) String() string {
+	if str, ok := leaseStateStrings[s]; ok {
+		return str
+	}
+	return fmt.Sprintf("state(%d)", int(s))
+}
+
+// Lease is the binding of an address to a client.
+type Lease struct {
+	IP net.IP
+
+	// ClientID identifies the client, as returned by Packet.GetClientID.
+	ClientID ClientID
+
+	// HardwareAddr is the client hardware address ('chaddr') of the client.
+	HardwareAddr net.HardwareAddr
+
+	State   LeaseState
+	Expires time.Time
+
+	// Version is set by the store, and changes every time the lease is
+	// written. It is compared by the operations of a LeaseStore that change
+	// a lease, so that concurrent changes are detected.
+	Version uint64
+}
+
+// LeaseStore keeps the leases of a server. An address has at most one lease,
+// and a client has at most one lease that is offered or bound. Leases that
+// expired are no longer in force: they are not returned, and their address
+// can be leased again, even before Expire removes them.
+//
+// The operations that change a lease take the lease as last read from the
+// store, and fail with ErrLeaseConflict if its version changed in the
+// meantime, so that a handler can read a lease, decide what to do, and write
+// it back without locking out the handlers of other requests. After a
+// conflict, the handler should read the lease again and start over, or drop
+// the request and let the client retransmit it.
+//
+// Implementations must be safe for concurrent use.
+type LeaseStore interface {
+	// GetByIP returns the lease of an address, including declined ones.
+	GetByIP(ip net.IP) (Lease, error)
+
+	// GetByClientID returns the offered or bound lease of a client.
+	GetByClientID(id ClientID) (Lease, error)
+
+	// GetByHardwareAddr returns the offered or bound lease of a client
+	// hardware address. If clients with different identifiers share it, the
+	// lease that expires last is returned.
+	GetByHardwareAddr(addr net.HardwareAddr) (Lease, error)
+
+	// Create stores a new lease and returns it with its version set. It
+	// fails with ErrLeaseConflict if the address has a lease, or if the
+	// client has an offered or bound lease of another address.
+	Create(l Lease) (Lease, error)
+
+	// Renew binds lease l until expires, whether it was offered or bound, and
+	// returns the updated lease.
+	Renew(l Lease, expires time.Time) (Lease, error)
+
+	// Release removes lease l, when the client relinquishes its address.
+	Release(l Lease) error
+
+	// Decline marks the address of lease l as declined until the specified
+	// time, so that it is not leased while it is in use by another host. The
+	// address no longer belongs to the client.
+	Decline(l Lease, until time.Time) (Lease, error)
+
+	// Expire removes the leases that expired by now, and returns them.
+	Expire(now time.Time) ([]Lease, error)
+}
@@ -0,0 +1,51 @@
+package dhcp4
+
+import (
+	"context"
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// listenDevice listens on addr with a UDP socket that is bound to the
+// specified device. Sockets bound to different devices can share a port.
+func listenDevice(name, addr string) (net.PacketConn, error) {
+	lc := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var err error
+			cerr := c.Control(func(fd uintptr) {
+				err = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, name)
+				if err == nil {
+					err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+				}
+			})
+			if cerr != nil {
+				return cerr
+			}
+			return err
+		},
+	}
+
+	return lc.ListenPacket(context.Background(), "udp4", addr)
+}
+
+// listenReusePort listens on addr with a UDP socket that has SO_REUSEPORT set,
+// so that the kernel spreads the packets sent to addr over all the sockets
+// listening on it.
+func listenReusePort(addr string) (net.PacketConn, error) {
+	lc := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var err error
+			cerr := c.Control(func(fd uintptr) {
+				err = syscall.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+			})
+			if cerr != nil {
+				return cerr
+			}
+			return err
+		},
+	}
+
+	return lc.ListenPacket(context.Background(), "udp4", addr)
+}
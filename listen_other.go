@@ -0,0 +1,13 @@
+//go:build !linux
+
+package dhcp4
+
+import "net"
+
+func listenDevice(name, addr string) (net.PacketConn, error) {
+	return nil, errBindToDeviceUnsupported
+}
+
+func listenReusePort(addr string) (net.PacketConn, error) {
+	return nil, errReusePortUnsupported
+}
@@ -4,24 +4,17 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"log/slog"
 	"net"
 	"strings"
 	"time"
-
-	// "github.com/packethost/pkg/log"
 )
 
-// var dlog log.Logger
-
-// func Init(l log.Logger) {
-//	dlog = l.Package("dhcp")
-// }
-
 var optionFormats = map[Option]func([]byte) []interface{}{
 	OptionDHCPMsgType:    nil,
 	OptionDHCPMaxMsgSize: nil, // func(b []byte) string { return fmt.Sprintf("max_msg_size=%d", binary.BigEndian.Uint16(b)) },
 	OptionParameterList:  nil, // func(b []byte) string { return "param_list=..." }
-	OptionClientID:       func(b []byte) []interface{} { return []interface{}{"client_id", formatHex(b)} },
+	OptionClientID:       func(b []byte) []interface{} { return []interface{}{"client_id", formatClientID(b)} },
 	OptionClientNDI:      func(b []byte) []interface{} { return []interface{}{"client_ndi", formatNDI(b)} },
 	OptionDHCPServerID:   func(b []byte) []interface{} { return []interface{}{"dhcp_server", net.IP(b).String()} },
 	OptionDomainServer:   func(b []byte) []interface{} { return []interface{}{"dns", formatIP(b)} },
@@ -45,20 +38,30 @@ func SetOptionFormatter(o Option, fn func([]byte) []interface{}) {
 	optionFormats[o] = fn
 }
 
+// formatHex formats b as colon separated hex bytes, such as 01:02:03:04. The
+// result isn't quoted, so that structured log handlers that do their own
+// quoting, such as slog.JSONHandler, don't quote it twice.
 func formatHex(b []byte) string {
 	const hex = "0123456789abcdef"
 
-	buf := append(make([]byte, 0, len(b)*2+len(b)+2), '"')
+	buf := make([]byte, 0, len(b)*3)
 	for i, c := range b {
 		if i > 0 {
 			buf = append(buf, ':')
 		}
 		buf = append(buf, hex[c>>4], hex[c&0xF])
 	}
-	buf = append(buf, '"')
 	return string(buf)
 }
 
+func formatClientID(b []byte) string {
+	c, err := ParseClientID(b)
+	if err != nil {
+		return formatHex(b)
+	}
+	return c.String()
+}
+
 func formatIP(b []byte) string {
 	if len(b)%4 != 0 {
 		return fmt.Sprintf("%q", b)
@@ -135,8 +138,8 @@ func optionFields(om OptionMap) []interface{} {
 			continue
 		}
 
-		if fields := fn(om[o]); fields != nil {
-			fields = append(fields, fields...)
+		if f := fn(om[o]); f != nil {
+			fields = append(fields, f...)
 		}
 	}
 	return fields
@@ -158,8 +161,8 @@ func toFields(event string, ifindex int, ip net.IP, req, resp *Packet) []interfa
 		gi, ip,
 	}
 
-	if iface, err := net.InterfaceByIndex(ifindex); err == nil {
-		fields = append(fields, "iface", iface.Name)
+	if iface, ok := hostInterfaces.lookup(ifindex); ok {
+		fields = append(fields, "iface", iface.name)
 	}
 
 	if resp == nil {
@@ -168,3 +171,26 @@ func toFields(event string, ifindex int, ip net.IP, req, resp *Packet) []interfa
 		return append(fields, getPacketFields(resp)...)
 	}
 }
+
+// fieldsToAttrs converts a list of alternating keys and values, as returned by
+// toFields, to slog attributes. Values that implement fmt.Stringer are logged
+// as strings, so that message types aren't logged as numbers by handlers that
+// don't use fmt, such as slog.JSONHandler.
+func fieldsToAttrs(fields []interface{}) []slog.Attr {
+	attrs := make([]slog.Attr, 0, len(fields)/2)
+	for i := 0; i+1 < len(fields); i += 2 {
+		v := fields[i+1]
+		if s, ok := v.(fmt.Stringer); ok {
+			v = s.String()
+		}
+		attrs = append(attrs, slog.Any(fmt.Sprint(fields[i]), v))
+	}
+	return attrs
+}
+
+// LogValue implements slog.LogValuer. It logs the packet as a group of its
+// header fields and options, using the formatters set with
+// SetOptionFormatter.
+func (p Packet) LogValue() slog.Value {
+	return slog.GroupValue(fieldsToAttrs(getPacketFields(&p))...)
+}
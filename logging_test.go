@@ -0,0 +1,123 @@
+package dhcp4
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOptionFields(t *testing.T) {
+	om := make(OptionMap)
+	om.SetMessageType(MessageTypeDiscover)
+	om.SetString(OptionHostname, "foo")
+	om.SetOption(Option(250), []byte{1})
+
+	// The message type has a nil formatter, and is skipped
+	expected := []interface{}{
+		"hostname", "foo",
+		"option(250)", []byte{1},
+	}
+
+	assert.Equal(t, expected, optionFields(om))
+}
+
+func TestPacketLogValue(t *testing.T) {
+	p := NewPacket(BootRequest)
+	p.SetMessageType(MessageTypeDiscover)
+	p.SetString(OptionHostname, "foo")
+	copy(p.XID(), []byte{1, 2, 3, 4})
+
+	var buf bytes.Buffer
+	slog.New(slog.NewJSONHandler(&buf, nil)).Info("test", "packet", p)
+
+	var record struct {
+		Packet map[string]interface{}
+	}
+	if assert.NoError(t, json.Unmarshal(buf.Bytes(), &record)) {
+		assert.Equal(t, map[string]interface{}{
+			"xid":      "01:02:03:04",
+			"type":     "DHCPDISCOVER",
+			"hostname": "foo",
+		}, record.Packet)
+	}
+}
+
+func TestServerLogger(t *testing.T) {
+	pc := newChanPacketConn()
+
+	var buf bytes.Buffer
+	s := Server{
+		Handler: HandlerFunc(func(w ReplyWriter, p *Packet) {
+			// Missing server identifier
+			nak := CreateNak(p)
+			w.WriteReply(&nak)
+		}),
+		Logger: slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})),
+	}
+
+	errc := make(chan error)
+	go func() { errc <- s.Serve(context.Background(), pc) }()
+
+	release := NewPacket(BootRequest)
+	release.SetMessageType(MessageTypeRelease)
+	release.SetCIAddr(net.IP{10, 0, 0, 2})
+	releaseBytes, err := PacketToBytes(release, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	discover := newTestDiscover(t)
+	copy(discover[4:8], []byte{1, 2, 3, 4})
+	discover[1], discover[2] = 1, 6
+	copy(discover[28:34], []byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x55})
+
+	pc.in <- []byte("garbage")
+	pc.in <- discover
+	pc.in <- releaseBytes
+	pc.Close()
+	<-errc
+
+	var events []string
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var record struct {
+			Level string
+			Event string
+			Type  string
+			Mac   string
+			XID   string
+		}
+		if !assert.NoError(t, dec.Decode(&record)) {
+			break
+		}
+		events = append(events, record.Level+" "+record.Event+" "+record.Type)
+		if record.Level == "ERROR" {
+			// Hardware address and xid are logged as plain strings
+			assert.Equal(t, "00:11:22:33:44:55", record.Mac)
+			assert.Equal(t, "01:02:03:04", record.XID)
+		}
+	}
+
+	// Parse errors and ErrNoReply for the DHCPRELEASE are only logged at
+	// debug level
+	assert.Equal(t, []string{
+		"DEBUG error ",
+		"DEBUG recv DHCPDISCOVER",
+		"ERROR error ",
+		"DEBUG recv DHCPRELEASE",
+		"DEBUG error ",
+	}, events)
+}
+
+func TestToFieldsRelayed(t *testing.T) {
+	p := NewPacket(BootRequest)
+	p.SetGIAddr(net.IP{10, 0, 0, 1})
+
+	fields := toFields("recv", 0, net.IP{10, 0, 0, 1}, &p, nil)
+	assert.Equal(t, []interface{}{"event", "recv", "mac", p.GetCHAddr(), "via", net.IP{10, 0, 0, 1}}, fields[:6])
+}
@@ -0,0 +1,312 @@
+package dhcp4
+
+import (
+	"container/heap"
+	"net"
+	"sync"
+	"time"
+)
+
+// MemoryLeaseStore is a LeaseStore that keeps leases in memory. Leases are
+// indexed by address, client identifier and client hardware address, and by
+// expiry time, so that Expire only visits the leases that expired. The zero
+// value is an empty store ready to use.
+//
+// Leases are lost when the process exits. Handlers that need to keep them
+// should use a store that writes them to disk, or to a database.
+type MemoryLeaseStore struct {
+	mu       sync.Mutex
+	byIP     map[string]*leaseEntry
+	byClient map[string]*leaseEntry
+	byHW     map[string][]*leaseEntry
+	expiry   leaseHeap
+	version  uint64
+	now      func() time.Time
+}
+
+type leaseEntry struct {
+	lease Lease
+
+	// Position in the expiry heap
+	index int
+}
+
+// GetByIP returns the lease of an address, including declined ones.
+func (s *MemoryLeaseStore) GetByIP(ip net.IP) (Lease, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := s.byIP[string(ip.To4())]
+	if e == nil || !s.current(e) {
+		return Lease{}, ErrLeaseNotFound
+	}
+	return cloneLease(e.lease), nil
+}
+
+// GetByClientID returns the offered or bound lease of a client.
+func (s *MemoryLeaseStore) GetByClientID(id ClientID) (Lease, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := s.byClient[id.Key()]
+	if e == nil || !s.current(e) {
+		return Lease{}, ErrLeaseNotFound
+	}
+	return cloneLease(e.lease), nil
+}
+
+// GetByHardwareAddr returns the offered or bound lease of a client hardware
+// address that expires last.
+func (s *MemoryLeaseStore) GetByHardwareAddr(addr net.HardwareAddr) (Lease, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var last *leaseEntry
+	for _, e := range s.byHW[string(addr)] {
+		if s.current(e) && (last == nil || e.lease.Expires.After(last.lease.Expires)) {
+			last = e
+		}
+	}
+	if last == nil {
+		return Lease{}, ErrLeaseNotFound
+	}
+	return cloneLease(last.lease), nil
+}
+
+// Create stores a new lease and returns it with its version set.
+func (s *MemoryLeaseStore) Create(l Lease) (Lease, error) {
+	ip := l.IP.To4()
+	if ip == nil || l.Expires.IsZero() || leaseStateStrings[l.State] == "" {
+		return Lease{}, ErrInvalidLease
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e := s.byIP[string(ip)]; e != nil {
+		if s.current(e) {
+			return Lease{}, ErrLeaseConflict
+		}
+		s.remove(e)
+	}
+
+	if l.State != LeaseDeclined {
+		if e := s.byClient[l.ClientID.Key()]; e != nil {
+			if s.current(e) {
+				return Lease{}, ErrLeaseConflict
+			}
+			s.remove(e)
+		}
+	}
+
+	l = cloneLease(l)
+	l.IP = ip
+	e := &leaseEntry{lease: l}
+	s.write(e)
+	s.insert(e)
+	return cloneLease(e.lease), nil
+}
+
+// Renew binds lease l until expires, and returns the updated lease.
+func (s *MemoryLeaseStore) Renew(l Lease, expires time.Time) (Lease, error) {
+	if expires.IsZero() {
+		return Lease{}, ErrInvalidLease
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, err := s.lookup(l)
+	if err != nil {
+		return Lease{}, err
+	}
+	if e.lease.State == LeaseDeclined {
+		return Lease{}, ErrLeaseConflict
+	}
+
+	e.lease.State = LeaseBound
+	e.lease.Expires = expires
+	s.write(e)
+	heap.Fix(&s.expiry, e.index)
+	return cloneLease(e.lease), nil
+}
+
+// Release removes lease l.
+func (s *MemoryLeaseStore) Release(l Lease) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, err := s.lookup(l)
+	if err != nil {
+		return err
+	}
+
+	s.remove(e)
+	return nil
+}
+
+// Decline marks the address of lease l as declined until the specified time.
+func (s *MemoryLeaseStore) Decline(l Lease, until time.Time) (Lease, error) {
+	if until.IsZero() {
+		return Lease{}, ErrInvalidLease
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, err := s.lookup(l)
+	if err != nil {
+		return Lease{}, err
+	}
+
+	s.unindexClient(e)
+	e.lease.State = LeaseDeclined
+	e.lease.Expires = until
+	s.write(e)
+	heap.Fix(&s.expiry, e.index)
+	return cloneLease(e.lease), nil
+}
+
+// Expire removes the leases that expired by now, and returns them in the
+// order they expired.
+func (s *MemoryLeaseStore) Expire(now time.Time) ([]Lease, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var expired []Lease
+	for len(s.expiry) > 0 && !now.Before(s.expiry[0].lease.Expires) {
+		e := s.expiry[0]
+		s.remove(e)
+		expired = append(expired, e.lease)
+	}
+	return expired, nil
+}
+
+// Len returns the number of leases in the store, including the ones that
+// expired but were not removed by Expire yet.
+func (s *MemoryLeaseStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.byIP)
+}
+
+// lookup returns the entry of lease l, if it is in force and was not written
+// since l was read.
+func (s *MemoryLeaseStore) lookup(l Lease) (*leaseEntry, error) {
+	e := s.byIP[string(l.IP.To4())]
+	if e == nil || !s.current(e) {
+		return nil, ErrLeaseNotFound
+	}
+	if e.lease.Version != l.Version {
+		return nil, ErrLeaseConflict
+	}
+	return e, nil
+}
+
+// current returns whether the lease of e is in force.
+func (s *MemoryLeaseStore) current(e *leaseEntry) bool {
+	return s.clock().Before(e.lease.Expires)
+}
+
+// write gives the lease of e a new version.
+func (s *MemoryLeaseStore) write(e *leaseEntry) {
+	s.version++
+	e.lease.Version = s.version
+}
+
+func (s *MemoryLeaseStore) insert(e *leaseEntry) {
+	if s.byIP == nil {
+		s.byIP = make(map[string]*leaseEntry)
+		s.byClient = make(map[string]*leaseEntry)
+		s.byHW = make(map[string][]*leaseEntry)
+	}
+
+	s.byIP[string(e.lease.IP)] = e
+	heap.Push(&s.expiry, e)
+
+	if e.lease.State == LeaseDeclined {
+		return
+	}
+	if key := e.lease.ClientID.Key(); key != "" {
+		s.byClient[key] = e
+	}
+	if hw := string(e.lease.HardwareAddr); hw != "" {
+		s.byHW[hw] = append(s.byHW[hw], e)
+	}
+}
+
+func (s *MemoryLeaseStore) remove(e *leaseEntry) {
+	delete(s.byIP, string(e.lease.IP))
+	heap.Remove(&s.expiry, e.index)
+	s.unindexClient(e)
+}
+
+// unindexClient removes e from the indexes by client.
+func (s *MemoryLeaseStore) unindexClient(e *leaseEntry) {
+	key := e.lease.ClientID.Key()
+	if s.byClient[key] == e {
+		delete(s.byClient, key)
+	}
+
+	hw := string(e.lease.HardwareAddr)
+	entries := s.byHW[hw]
+	for i, other := range entries {
+		if other == e {
+			entries = append(entries[:i], entries[i+1:]...)
+			break
+		}
+	}
+	if len(entries) == 0 {
+		delete(s.byHW, hw)
+	} else {
+		s.byHW[hw] = entries
+	}
+}
+
+func (s *MemoryLeaseStore) clock() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
+
+// cloneLease returns a copy of l that doesn't share its addresses.
+func cloneLease(l Lease) Lease {
+	l.IP = append(net.IP(nil), l.IP...)
+	if l.HardwareAddr != nil {
+		l.HardwareAddr = append(net.HardwareAddr(nil), l.HardwareAddr...)
+	}
+	return l
+}
+
+// leaseHeap orders leases by expiry time, for container/heap.
+type leaseHeap []*leaseEntry
+
+func (h leaseHeap) Len() int {
+	return len(h)
+}
+
+func (h leaseHeap) Less(i, j int) bool {
+	return h[i].lease.Expires.Before(h[j].lease.Expires)
+}
+
+func (h leaseHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *leaseHeap) Push(x interface{}) {
+	e := x.(*leaseEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *leaseHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return e
+}
@@ -0,0 +1,201 @@
+package dhcp4
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestLeaseStore() (*MemoryLeaseStore, *time.Time) {
+	now := time.Unix(1000, 0)
+	s := &MemoryLeaseStore{}
+	s.now = func() time.Time { return now }
+	return s, &now
+}
+
+func testLease(ip byte, client byte) Lease {
+	hw := net.HardwareAddr{0, 1, 2, 3, 4, client}
+	return Lease{
+		IP:           net.IP{10, 0, 0, ip},
+		ClientID:     NewHardwareClientID(1, hw),
+		HardwareAddr: hw,
+		State:        LeaseOffered,
+		Expires:      time.Unix(1060, 0),
+	}
+}
+
+func TestMemoryLeaseStoreCreate(t *testing.T) {
+	s, _ := newTestLeaseStore()
+
+	l, err := s.Create(testLease(10, 1))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NotZero(t, l.Version)
+
+	for _, get := range []func() (Lease, error){
+		func() (Lease, error) { return s.GetByIP(net.IPv4(10, 0, 0, 10)) },
+		func() (Lease, error) { return s.GetByClientID(l.ClientID) },
+		func() (Lease, error) { return s.GetByHardwareAddr(l.HardwareAddr) },
+	} {
+		got, err := get()
+		assert.NoError(t, err)
+		assert.Equal(t, l, got)
+	}
+
+	_, err = s.GetByIP(net.IP{10, 0, 0, 11})
+	assert.Equal(t, ErrLeaseNotFound, err)
+
+	// The address is taken
+	_, err = s.Create(testLease(10, 2))
+	assert.Equal(t, ErrLeaseConflict, err)
+
+	// The client already has a lease
+	_, err = s.Create(testLease(11, 1))
+	assert.Equal(t, ErrLeaseConflict, err)
+
+	_, err = s.Create(Lease{IP: net.ParseIP("2001:db8::1"), State: LeaseBound, Expires: time.Unix(1060, 0)})
+	assert.Equal(t, ErrInvalidLease, err)
+	_, err = s.Create(Lease{IP: net.IP{10, 0, 0, 12}, State: LeaseBound})
+	assert.Equal(t, ErrInvalidLease, err)
+}
+
+func TestMemoryLeaseStoreCompareAndSwap(t *testing.T) {
+	s, _ := newTestLeaseStore()
+
+	offered, err := s.Create(testLease(10, 1))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	bound, err := s.Renew(offered, time.Unix(4600, 0))
+	if assert.NoError(t, err) {
+		assert.Equal(t, LeaseBound, bound.State)
+		assert.Equal(t, time.Unix(4600, 0), bound.Expires)
+		assert.NotEqual(t, offered.Version, bound.Version)
+	}
+
+	// The lease changed since it was offered
+	_, err = s.Renew(offered, time.Unix(4600, 0))
+	assert.Equal(t, ErrLeaseConflict, err)
+	assert.Equal(t, ErrLeaseConflict, s.Release(offered))
+	_, err = s.Decline(offered, time.Unix(4600, 0))
+	assert.Equal(t, ErrLeaseConflict, err)
+
+	assert.NoError(t, s.Release(bound))
+	assert.Equal(t, ErrLeaseNotFound, s.Release(bound))
+	_, err = s.GetByClientID(bound.ClientID)
+	assert.Equal(t, ErrLeaseNotFound, err)
+	assert.Equal(t, 0, s.Len())
+}
+
+func TestMemoryLeaseStoreDecline(t *testing.T) {
+	s, _ := newTestLeaseStore()
+
+	l, err := s.Create(testLease(10, 1))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	declined, err := s.Decline(l, time.Unix(2000, 0))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, LeaseDeclined, declined.State)
+
+	got, err := s.GetByIP(l.IP)
+	assert.NoError(t, err)
+	assert.Equal(t, declined, got)
+
+	// The address no longer belongs to the client, which can lease another
+	_, err = s.GetByClientID(l.ClientID)
+	assert.Equal(t, ErrLeaseNotFound, err)
+	_, err = s.GetByHardwareAddr(l.HardwareAddr)
+	assert.Equal(t, ErrLeaseNotFound, err)
+
+	_, err = s.Create(testLease(11, 1))
+	assert.NoError(t, err)
+	_, err = s.Create(testLease(10, 2))
+	assert.Equal(t, ErrLeaseConflict, err)
+	_, err = s.Renew(declined, time.Unix(4600, 0))
+	assert.Equal(t, ErrLeaseConflict, err)
+}
+
+func TestMemoryLeaseStoreExpire(t *testing.T) {
+	s, now := newTestLeaseStore()
+
+	for i, expires := range []int64{1300, 1100, 1200} {
+		l := testLease(byte(10+i), byte(i))
+		l.Expires = time.Unix(expires, 0)
+		_, err := s.Create(l)
+		assert.NoError(t, err)
+	}
+
+	// Expired leases are not in force, even before they are removed
+	*now = time.Unix(1100, 0)
+	_, err := s.GetByIP(net.IP{10, 0, 0, 11})
+	assert.Equal(t, ErrLeaseNotFound, err)
+	_, err = s.Create(testLease(11, 9))
+	assert.NoError(t, err)
+
+	*now = time.Unix(1250, 0)
+	expired, err := s.Expire(*now)
+	assert.NoError(t, err)
+	var ips []string
+	for _, l := range expired {
+		ips = append(ips, l.IP.String())
+	}
+	assert.Equal(t, []string{"10.0.0.11", "10.0.0.12"}, ips)
+	assert.Equal(t, 1, s.Len())
+
+	_, err = s.GetByIP(net.IP{10, 0, 0, 10})
+	assert.NoError(t, err)
+}
+
+func TestMemoryLeaseStoreConcurrent(t *testing.T) {
+	var s MemoryLeaseStore
+
+	const clients = 16
+	var wg sync.WaitGroup
+	created := make(chan Lease, clients)
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			l := testLease(10, byte(i))
+			l.Expires = time.Now().Add(time.Hour)
+			if l, err := s.Create(l); err == nil {
+				created <- l
+			} else {
+				assert.Equal(t, ErrLeaseConflict, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(created)
+
+	// Only one client gets the address
+	if assert.Len(t, created, 1) {
+		l := <-created
+
+		// Only one renewal of the same version succeeds
+		var renewed int
+		var mu sync.Mutex
+		for i := 0; i < clients; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := s.Renew(l, time.Now().Add(2*time.Hour)); err == nil {
+					mu.Lock()
+					renewed++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, 1, renewed)
+	}
+}
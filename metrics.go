@@ -0,0 +1,304 @@
+package dhcp4
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics receives events from a Server and its ReplyWriters for monitoring.
+// Implementations must be safe for concurrent use.
+type Metrics interface {
+	// PacketReceived is called for every request that is passed on to the
+	// handler, right before the handler is called. Requests that are queued
+	// for a worker but dropped later aren't counted as received.
+	PacketReceived(t MessageType)
+
+	// ReplySent is called for every reply that is written to the network.
+	ReplySent(t MessageType)
+
+	// ParseError is called for every packet that cannot be parsed. The cause
+	// is one of "short_packet", "truncated_options" or "other".
+	ParseError(cause string)
+
+	// PacketDropped is called for every packet that is not passed on to the
+	// handler for another reason than a parse error. The reason is one of
+	// "not_request", "queue_full" or "shutdown".
+	PacketDropped(reason string)
+
+	// ValidationFailed is called for every violation in a reply that fails
+	// validation. The violation is the code of the offending option, the name
+	// of the offending header field, or "other".
+	ValidationFailed(violation string)
+
+	// WriteError is called for every reply that fails to be written to the
+	// network.
+	WriteError()
+
+	// HandlerDuration is called after the handler returns, with the time it
+	// took to handle the request.
+	HandlerDuration(t MessageType, d time.Duration)
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) PacketReceived(t MessageType)                   {}
+func (nopMetrics) ReplySent(t MessageType)                        {}
+func (nopMetrics) ParseError(cause string)                        {}
+func (nopMetrics) PacketDropped(reason string)                    {}
+func (nopMetrics) ValidationFailed(violation string)              {}
+func (nopMetrics) WriteError()                                    {}
+func (nopMetrics) HandlerDuration(t MessageType, d time.Duration) {}
+
+// parseErrorCause returns the cause of a PacketFromBytes error, as reported to
+// Metrics.ParseError.
+func parseErrorCause(b []byte, err error) string {
+	if !errors.Is(err, ErrShortPacket) {
+		return "other"
+	}
+	if len(b) < 240 {
+		return "short_packet"
+	}
+	return "truncated_options"
+}
+
+// violations returns the violations in a validation error, as reported to
+// Metrics.ValidationFailed.
+func violations(err error) []string {
+	errs, ok := err.(ValidationErrors)
+	if !ok {
+		errs = ValidationErrors{err}
+	}
+
+	var vs []string
+	for _, err := range errs {
+		switch e := err.(type) {
+		case *ValidationError:
+			vs = append(vs, strconv.Itoa(int(e.Option)))
+		case *EchoValidationError:
+			vs = append(vs, strconv.Itoa(int(e.Option)))
+		case *FieldValidationError:
+			vs = append(vs, e.Field.String())
+		case *ConsistencyError:
+			for _, o := range e.Options {
+				vs = append(vs, strconv.Itoa(int(o)))
+			}
+		default:
+			vs = append(vs, "other")
+		}
+	}
+	return vs
+}
+
+// DefaultDurationBuckets are the upper bounds, in seconds, of the buckets of
+// the handler duration histogram of PrometheusMetrics.
+var DefaultDurationBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// PrometheusMetrics implements Metrics by keeping counters and histograms in
+// memory. It implements http.Handler to serve them in the Prometheus text
+// exposition format.
+type PrometheusMetrics struct {
+	received   *counterVec
+	sent       *counterVec
+	parse      *counterVec
+	dropped    *counterVec
+	validation *counterVec
+	write      *counterVec
+	duration   *histogramVec
+}
+
+// NewPrometheusMetrics returns a PrometheusMetrics with metric names prefixed
+// by "dhcp4_".
+func NewPrometheusMetrics() *PrometheusMetrics {
+	return &PrometheusMetrics{
+		received:   newCounterVec("dhcp4_packets_received_total", "Requests received, by message type.", "type"),
+		sent:       newCounterVec("dhcp4_replies_sent_total", "Replies sent, by message type.", "type"),
+		parse:      newCounterVec("dhcp4_parse_errors_total", "Packets that could not be parsed, by cause.", "cause"),
+		dropped:    newCounterVec("dhcp4_packets_dropped_total", "Packets that were not handled, by reason.", "reason"),
+		validation: newCounterVec("dhcp4_reply_validation_failures_total", "Violations in replies that failed validation, by option or field.", "violation"),
+		write:      newCounterVec("dhcp4_write_errors_total", "Replies that could not be written to the network.", ""),
+		duration:   newHistogramVec("dhcp4_handler_duration_seconds", "Time spent in the handler, by message type.", "type", DefaultDurationBuckets),
+	}
+}
+
+func (m *PrometheusMetrics) PacketReceived(t MessageType) {
+	m.received.inc(t.String())
+}
+
+func (m *PrometheusMetrics) ReplySent(t MessageType) {
+	m.sent.inc(t.String())
+}
+
+func (m *PrometheusMetrics) ParseError(cause string) {
+	m.parse.inc(cause)
+}
+
+func (m *PrometheusMetrics) PacketDropped(reason string) {
+	m.dropped.inc(reason)
+}
+
+func (m *PrometheusMetrics) ValidationFailed(violation string) {
+	m.validation.inc(violation)
+}
+
+func (m *PrometheusMetrics) WriteError() {
+	m.write.inc("")
+}
+
+func (m *PrometheusMetrics) HandlerDuration(t MessageType, d time.Duration) {
+	m.duration.observe(t.String(), d.Seconds())
+}
+
+// WriteTo writes all metrics in the Prometheus text exposition format.
+func (m *PrometheusMetrics) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+	m.received.write(&b)
+	m.sent.write(&b)
+	m.parse.write(&b)
+	m.dropped.write(&b)
+	m.validation.write(&b)
+	m.write.write(&b)
+	m.duration.write(&b)
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// ServeHTTP serves all metrics in the Prometheus text exposition format.
+func (m *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabel formats a label pair, or returns an empty string for metrics
+// without a label.
+func formatLabel(name, value string) string {
+	if name == "" {
+		return ""
+	}
+	return fmt.Sprintf(`%s="%s"`, name, labelValueEscaper.Replace(value))
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+type counterVec struct {
+	name, help, label string
+
+	mu     sync.Mutex
+	values map[string]uint64
+}
+
+func newCounterVec(name, help, label string) *counterVec {
+	return &counterVec{
+		name:   name,
+		help:   help,
+		label:  label,
+		values: make(map[string]uint64),
+	}
+}
+
+func (c *counterVec) inc(value string) {
+	c.mu.Lock()
+	c.values[value]++
+	c.mu.Unlock()
+}
+
+func (c *counterVec) write(b *strings.Builder) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+
+	// Metrics without a label are always present
+	if c.label == "" {
+		fmt.Fprintf(b, "%s %d\n", c.name, c.values[""])
+		return
+	}
+
+	keys := make([]string, 0, len(c.values))
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, v := range keys {
+		fmt.Fprintf(b, "%s{%s} %d\n", c.name, formatLabel(c.label, v), c.values[v])
+	}
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+type histogramVec struct {
+	name, help, label string
+	buckets           []float64
+
+	mu     sync.Mutex
+	values map[string]*histogram
+}
+
+func newHistogramVec(name, help, label string, buckets []float64) *histogramVec {
+	return &histogramVec{
+		name:    name,
+		help:    help,
+		label:   label,
+		buckets: buckets,
+		values:  make(map[string]*histogram),
+	}
+}
+
+func (h *histogramVec) observe(value string, f float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	v, ok := h.values[value]
+	if !ok {
+		v = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.values[value] = v
+	}
+
+	for i, le := range h.buckets {
+		if f <= le {
+			v.counts[i]++
+		}
+	}
+	v.sum += f
+	v.count++
+}
+
+func (h *histogramVec) write(b *strings.Builder) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+
+	keys := make([]string, 0, len(h.values))
+	for k := range h.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, value := range keys {
+		v := h.values[value]
+		label := formatLabel(h.label, value)
+		for i, le := range h.buckets {
+			fmt.Fprintf(b, "%s_bucket{%s,le=\"%s\"} %d\n", h.name, label, formatFloat(le), v.counts[i])
+		}
+		fmt.Fprintf(b, "%s_bucket{%s,le=\"+Inf\"} %d\n", h.name, label, v.count)
+		fmt.Fprintf(b, "%s_sum{%s} %s\n", h.name, label, formatFloat(v.sum))
+		fmt.Fprintf(b, "%s_count{%s} %d\n", h.name, label, v.count)
+	}
+}
@@ -0,0 +1,185 @@
+package dhcp4
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrometheusMetrics(t *testing.T) {
+	m := NewPrometheusMetrics()
+	m.PacketReceived(MessageTypeDiscover)
+	m.PacketReceived(MessageTypeDiscover)
+	m.PacketReceived(MessageTypeRequest)
+	m.ReplySent(MessageTypeOffer)
+	m.ParseError("short_packet")
+	m.PacketDropped("queue_full")
+	m.ValidationFailed("54")
+	m.WriteError()
+	m.HandlerDuration(MessageTypeDiscover, 3*time.Millisecond)
+	m.HandlerDuration(MessageTypeDiscover, 2*time.Second)
+
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", w.Header().Get("Content-Type"))
+
+	expected := []string{
+		"# TYPE dhcp4_packets_received_total counter",
+		`dhcp4_packets_received_total{type="DHCPDISCOVER"} 2`,
+		`dhcp4_packets_received_total{type="DHCPREQUEST"} 1`,
+		`dhcp4_replies_sent_total{type="DHCPOFFER"} 1`,
+		`dhcp4_parse_errors_total{cause="short_packet"} 1`,
+		`dhcp4_packets_dropped_total{reason="queue_full"} 1`,
+		`dhcp4_reply_validation_failures_total{violation="54"} 1`,
+		"dhcp4_write_errors_total 1",
+		"# TYPE dhcp4_handler_duration_seconds histogram",
+		`dhcp4_handler_duration_seconds_bucket{type="DHCPDISCOVER",le="0.0025"} 0`,
+		`dhcp4_handler_duration_seconds_bucket{type="DHCPDISCOVER",le="0.005"} 1`,
+		`dhcp4_handler_duration_seconds_bucket{type="DHCPDISCOVER",le="2.5"} 2`,
+		`dhcp4_handler_duration_seconds_bucket{type="DHCPDISCOVER",le="+Inf"} 2`,
+		`dhcp4_handler_duration_seconds_sum{type="DHCPDISCOVER"} 2.003`,
+		`dhcp4_handler_duration_seconds_count{type="DHCPDISCOVER"} 2`,
+	}
+
+	lines := strings.Split(w.Body.String(), "\n")
+	for _, line := range expected {
+		assert.Contains(t, lines, line)
+	}
+}
+
+func TestFormatLabelEscapes(t *testing.T) {
+	assert.Equal(t, `cause="a\\b\"c\nd"`, formatLabel("cause", "a\\b\"c\nd"))
+}
+
+func TestViolations(t *testing.T) {
+	err := ValidationErrors{
+		&ValidationError{Option: OptionDHCPServerID, MustHave: true},
+		&FieldValidationError{Field: FieldYIAddr, MustBeSet: true},
+		&ConsistencyError{Options: []Option{OptionRenewalTime, OptionRebindingTime}},
+		ErrInvalidPacket,
+	}
+
+	assert.Equal(t, []string{"54", "yiaddr", "58", "59", "other"}, violations(err))
+}
+
+// countingMetrics records the events it receives.
+type countingMetrics struct {
+	nopMetrics
+	events chan string
+}
+
+func (m *countingMetrics) PacketReceived(t MessageType) {
+	m.events <- "received " + t.String()
+}
+
+func (m *countingMetrics) ReplySent(t MessageType) {
+	m.events <- "sent " + t.String()
+}
+
+func (m *countingMetrics) ParseError(cause string) {
+	m.events <- "parse error " + cause
+}
+
+func (m *countingMetrics) PacketDropped(reason string) {
+	m.events <- "dropped " + reason
+}
+
+func (m *countingMetrics) ValidationFailed(violation string) {
+	m.events <- "invalid " + violation
+}
+
+func TestServerMetrics(t *testing.T) {
+	pc := newChanPacketConn()
+	m := &countingMetrics{events: make(chan string, 16)}
+
+	handled := make(chan struct{})
+	s := Server{
+		Handler: HandlerFunc(func(w ReplyWriter, p *Packet) {
+			nak := CreateNak(p)
+			w.WriteReply(&nak)
+
+			nak.SetOption(OptionDHCPServerID, []byte{10, 0, 0, 1})
+			w.WriteReply(&nak)
+			close(handled)
+		}),
+		Metrics: m,
+	}
+
+	errc := make(chan error)
+	go func() { errc <- s.Serve(context.Background(), pc) }()
+
+	reply, err := PacketToBytes(NewPacket(BootReply), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pc.in <- []byte("garbage")
+	pc.in <- reply
+	pc.in <- newTestDiscover(t)
+	<-handled
+	pc.Close()
+	<-errc
+	close(m.events)
+
+	var events []string
+	for e := range m.events {
+		events = append(events, e)
+	}
+
+	assert.Equal(t, []string{
+		"parse error short_packet",
+		"dropped not_request",
+		"received DHCPDISCOVER",
+		"invalid 54",
+		"sent DHCPNAK",
+	}, events)
+}
+
+func TestServerMetricsQueueFull(t *testing.T) {
+	pc := newChanPacketConn()
+	m := &countingMetrics{events: make(chan string, 16)}
+
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	s := Server{
+		Workers:   1,
+		QueueSize: 1,
+		Handler: HandlerFunc(func(w ReplyWriter, p *Packet) {
+			started <- struct{}{}
+			<-release
+		}),
+		Metrics: m,
+	}
+
+	errc := make(chan error)
+	go func() { errc <- s.Serve(context.Background(), pc) }()
+
+	// The first packet occupies the worker, the second fills the queue and
+	// the third is dropped
+	pc.in <- newTestDiscoverXID(t, 1)
+	<-started
+	pc.in <- newTestDiscoverXID(t, 2)
+	pc.in <- newTestDiscoverXID(t, 3)
+	close(release)
+
+	assert.NoError(t, s.Shutdown(context.Background()))
+	assert.Equal(t, ErrServerClosed, <-errc)
+	close(m.events)
+
+	var events []string
+	for e := range m.events {
+		events = append(events, e)
+	}
+
+	assert.Equal(t, []string{
+		"received DHCPDISCOVER",
+		"dropped queue_full",
+		"received DHCPDISCOVER",
+	}, events)
+	assert.Equal(t, ServerStats{Queued: 2, Dropped: 1}, s.Stats())
+}
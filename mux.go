@@ -0,0 +1,157 @@
+package dhcp4
+
+import (
+	"net"
+	"strings"
+	"sync"
+)
+
+// HandlerFunc adapts an ordinary function to the Handler interface.
+type HandlerFunc func(w ReplyWriter, p *Packet)
+
+// ServeDHCP calls f(w, p).
+func (f HandlerFunc) ServeDHCP(w ReplyWriter, p *Packet) {
+	f(w, p)
+}
+
+// Middleware wraps a Handler to add behavior that is common to all requests,
+// such as logging, rate limiting or metrics.
+type Middleware func(Handler) Handler
+
+// Chain wraps h in the specified middleware. The first middleware is the
+// outermost one, and sees every request first.
+func Chain(h Handler, m ...Middleware) Handler {
+	for i := len(m) - 1; i >= 0; i-- {
+		h = m[i](h)
+	}
+	return h
+}
+
+// Route describes the requests a handler registered with a ServeMux is called
+// for. Zero fields match any request.
+type Route struct {
+	MessageType MessageType
+
+	// IfIndex is the index of the interface the request arrived on.
+	IfIndex int
+
+	// GIAddr is the address of the relay agent that forwarded the request.
+	// Use net.IPv4zero to only match requests that were not relayed.
+	GIAddr net.IP
+
+	// VendorClass is a prefix of the vendor class identifier (option 60),
+	// for example "PXEClient".
+	VendorClass string
+}
+
+// specificity returns the number of fields the route matches on.
+func (r Route) specificity() int {
+	n := 0
+	if r.MessageType != 0 {
+		n++
+	}
+	if r.IfIndex != 0 {
+		n++
+	}
+	if r.GIAddr != nil {
+		n++
+	}
+	if r.VendorClass != "" {
+		n++
+	}
+	return n
+}
+
+func (r Route) match(w ReplyWriter, p *Packet) bool {
+	if r.MessageType != 0 && r.MessageType != p.GetMessageType() {
+		return false
+	}
+
+	if r.IfIndex != 0 && w.Info().IfIndex != r.IfIndex {
+		return false
+	}
+
+	if r.GIAddr != nil && !r.GIAddr.Equal(p.GetGIAddr()) {
+		return false
+	}
+
+	if r.VendorClass != "" {
+		v, ok := p.GetString(OptionClassID)
+		if !ok || !strings.HasPrefix(v, r.VendorClass) {
+			return false
+		}
+	}
+
+	return true
+}
+
+type muxEntry struct {
+	route Route
+	h     Handler
+}
+
+// ServeMux is a Handler that routes requests to the handler of the most
+// specific matching route. If several matching routes are equally specific,
+// the one registered first is used. Routes can be registered while the
+// ServeMux is serving requests.
+type ServeMux struct {
+	// NotFound is called for requests that match no route. If nil, these
+	// requests are ignored. Set it before serving requests.
+	NotFound Handler
+
+	mu      sync.RWMutex
+	entries []muxEntry
+}
+
+// NewServeMux returns a new ServeMux.
+func NewServeMux() *ServeMux {
+	return &ServeMux{}
+}
+
+// Handle registers the handler for the specified message type.
+func (m *ServeMux) Handle(t MessageType, h Handler) {
+	m.HandleRoute(Route{MessageType: t}, h)
+}
+
+// HandleFunc registers the handler function for the specified message type.
+func (m *ServeMux) HandleFunc(t MessageType, f func(w ReplyWriter, p *Packet)) {
+	m.Handle(t, HandlerFunc(f))
+}
+
+// HandleRoute registers the handler for the specified route.
+func (m *ServeMux) HandleRoute(r Route, h Handler) {
+	if h == nil {
+		panic("dhcp4: nil handler")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries = append(m.entries, muxEntry{route: r, h: h})
+}
+
+// Handler returns the handler to use for the request, or nil if no route
+// matches and NotFound is not set.
+func (m *ServeMux) Handler(w ReplyWriter, p *Packet) Handler {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var h Handler
+	best := -1
+	for _, e := range m.entries {
+		if n := e.route.specificity(); n > best && e.route.match(w, p) {
+			h, best = e.h, n
+		}
+	}
+
+	if h == nil {
+		return m.NotFound
+	}
+	return h
+}
+
+// ServeDHCP dispatches the request to the handler of the matching route.
+func (m *ServeMux) ServeDHCP(w ReplyWriter, p *Packet) {
+	if h := m.Handler(w, p); h != nil {
+		h.ServeDHCP(w, p)
+	}
+}
@@ -0,0 +1,140 @@
+package dhcp4
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingHandler returns a handler that appends name to calls.
+func recordingHandler(calls *[]string, name string) Handler {
+	return HandlerFunc(func(w ReplyWriter, p *Packet) {
+		*calls = append(*calls, name)
+	})
+}
+
+func newTestMuxPacket(t MessageType, giaddr net.IP, vendorClass string) *Packet {
+	p := NewPacket(BootRequest)
+	p.SetMessageType(t)
+	if giaddr != nil {
+		p.SetGIAddr(giaddr.To4())
+	}
+	if vendorClass != "" {
+		p.SetString(OptionClassID, vendorClass)
+	}
+	return &p
+}
+
+func TestServeMux(t *testing.T) {
+	var calls []string
+
+	m := NewServeMux()
+	m.Handle(MessageTypeDiscover, recordingHandler(&calls, "discover"))
+	m.Handle(MessageTypeRequest, recordingHandler(&calls, "request"))
+	m.HandleRoute(Route{MessageType: MessageTypeDiscover, VendorClass: "PXEClient"}, recordingHandler(&calls, "pxe"))
+	m.HandleRoute(Route{GIAddr: net.IPv4(10, 0, 0, 1)}, recordingHandler(&calls, "relay"))
+	m.HandleRoute(Route{MessageType: MessageTypeDiscover, IfIndex: 2}, recordingHandler(&calls, "eth1"))
+
+	testCases := []struct {
+		w        ReplyWriter
+		p        *Packet
+		expected string
+	}{
+		{
+			p:        newTestMuxPacket(MessageTypeDiscover, nil, ""),
+			expected: "discover",
+		},
+		{
+			p:        newTestMuxPacket(MessageTypeRequest, nil, "PXEClient"),
+			expected: "request",
+		},
+		{
+			p:        newTestMuxPacket(MessageTypeDiscover, nil, "PXEClient:Arch:00000"),
+			expected: "pxe",
+		},
+		{
+			p:        newTestMuxPacket(MessageTypeRelease, net.IPv4(10, 0, 0, 1), ""),
+			expected: "relay",
+		},
+		{
+			w:        &replyWriter{info: RequestInfo{IfIndex: 2}},
+			p:        newTestMuxPacket(MessageTypeDiscover, nil, ""),
+			expected: "eth1",
+		},
+		{
+			w:        &replyWriter{info: RequestInfo{IfIndex: 3}},
+			p:        newTestMuxPacket(MessageTypeDiscover, nil, ""),
+			expected: "discover",
+		},
+		{
+			// Equally specific: the first registered route wins
+			w:        &replyWriter{info: RequestInfo{IfIndex: 2}},
+			p:        newTestMuxPacket(MessageTypeDiscover, nil, "PXEClient"),
+			expected: "pxe",
+		},
+	}
+
+	for _, testCase := range testCases {
+		w := testCase.w
+		if w == nil {
+			w = &replyWriter{}
+		}
+
+		calls = nil
+		m.ServeDHCP(w, testCase.p)
+		assert.Equal(t, []string{testCase.expected}, calls)
+	}
+}
+
+func TestServeMuxNotFound(t *testing.T) {
+	var calls []string
+
+	m := NewServeMux()
+	m.Handle(MessageTypeDiscover, recordingHandler(&calls, "discover"))
+
+	// Ignored without NotFound
+	m.ServeDHCP(nil, newTestMuxPacket(MessageTypeDecline, nil, ""))
+	assert.Empty(t, calls)
+
+	m.NotFound = recordingHandler(&calls, "not found")
+	m.ServeDHCP(nil, newTestMuxPacket(MessageTypeDecline, nil, ""))
+	assert.Equal(t, []string{"not found"}, calls)
+}
+
+func TestServeMuxHandleWhileServing(t *testing.T) {
+	m := NewServeMux()
+	m.HandleFunc(MessageTypeDiscover, func(w ReplyWriter, p *Packet) {})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			m.HandleRoute(Route{IfIndex: i + 1}, HandlerFunc(func(w ReplyWriter, p *Packet) {}))
+		}
+	}()
+
+	p := newTestMuxPacket(MessageTypeDiscover, nil, "")
+	for i := 0; i < 100; i++ {
+		m.ServeDHCP(&replyWriter{}, p)
+	}
+	<-done
+}
+
+func TestChain(t *testing.T) {
+	var calls []string
+
+	middleware := func(name string) Middleware {
+		return func(h Handler) Handler {
+			return HandlerFunc(func(w ReplyWriter, p *Packet) {
+				calls = append(calls, name)
+				h.ServeDHCP(w, p)
+			})
+		}
+	}
+
+	h := Chain(recordingHandler(&calls, "handler"), middleware("outer"), middleware("inner"))
+	h.ServeDHCP(nil, newTestMuxPacket(MessageTypeDiscover, nil, ""))
+
+	assert.Equal(t, []string{"outer", "inner", "handler"}, calls)
+}
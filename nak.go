@@ -1,7 +1,5 @@
 package dhcp4
 
-import "encoding/binary"
-
 // Nak is a server to client packet indicating client's notion of network
 // address is incorrect (e.g., client has moved to new subnet) or client's
 // lease as expired.
@@ -9,6 +7,9 @@ type Nak struct {
 	Packet
 
 	msg *Packet
+
+	// Whether the client identifier is echoed (RFC6842)
+	echoClientID bool
 }
 
 func CreateNak(msg *Packet) Nak {
@@ -17,11 +18,18 @@ func CreateNak(msg *Packet) Nak {
 		msg:    msg,
 	}
 
+	rep.echoClientID = copyClientID(&rep.Packet, msg)
 	rep.SetMessageType(MessageTypeNak)
 	return rep
 }
 
 // From RFC2131, table 3:
+//   Field      DHCPNAK
+//   -----      -------
+//   'ciaddr'   0
+//   'yiaddr'   0
+//   'siaddr'   0
+//
 //   Option                    DHCPNAK
 //   ------                    -------
 //   Requested IP address      MUST NOT
@@ -30,7 +38,7 @@ func CreateNak(msg *Packet) Nak {
 //   DHCP message type         DHCPNAK
 //   Parameter request list    MUST NOT
 //   Message                   SHOULD
-//   Client identifier         MAY
+//   Client identifier         MAY (MUST echo, per RFC6842)
 //   Vendor class identifier   MAY
 //   Server identifier         MUST
 //   Maximum message size      MUST NOT
@@ -45,26 +53,36 @@ var dhcpNakAllowedOptions = []Option{
 }
 
 var dhcpNakValidation = []Validation{
+	ValidateFieldZero(FieldCIAddr),
+	ValidateFieldZero(FieldYIAddr),
+	ValidateFieldZero(FieldSIAddr),
 	ValidateMust(OptionDHCPServerID),
 	ValidateAllowedOptions(dhcpNakAllowedOptions),
 }
 
 func (d *Nak) Validate() error {
-	return Validate(d.Packet, dhcpNakValidation)
+	vs := dhcpNakValidation
+	if d.echoClientID {
+		vs = append([]Validation{ValidateEcho(OptionClientID, d.msg)}, vs...)
+	}
+
+	return Validate(d.Packet, vs)
 }
 
 func (d *Nak) ToBytes() ([]byte, error) {
-	opts := packetToBytesOptions{
-		skipFile:  true,
-		skipSName: true,
-	}
+	return d.AppendTo(nil)
+}
 
-	// Copy MaxMsgSize if set in the request
-	if v, ok := d.Message().GetOption(OptionDHCPMaxMsgSize); ok {
-		opts.maxLen = binary.BigEndian.Uint16(v)
-	}
+// AppendTo appends the wire-level representation of the reply to dst and
+// returns the extended buffer.
+func (d *Nak) AppendTo(dst []byte) ([]byte, error) {
+	opts := replyOptions(d.Message())
+
+	// Options don't go in the 'file' and 'sname' fields
+	opts.skipFile = true
+	opts.skipSName = true
 
-	return PacketToBytes(d.Packet, &opts)
+	return appendPacket(dst, d.Packet, &opts)
 }
 
 func (d *Nak) Message() *Packet {
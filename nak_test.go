@@ -1,6 +1,11 @@
 package dhcp4
 
-import "testing"
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
 
 func TestNakValidation(t *testing.T) {
 	testCase := replyValidationTestCase{
@@ -27,3 +32,31 @@ func TestNakValidation(t *testing.T) {
 
 	testCase.Test(t)
 }
+
+func TestNakClientIDEcho(t *testing.T) {
+	msg := NewPacket(BootRequest)
+	msg.SetOption(OptionClientID, []byte("\x00foo"))
+
+	rep := CreateNak(&msg)
+	rep.SetOption(OptionDHCPServerID, []byte("foo"))
+	assert.NoError(t, rep.Validate())
+
+	// Removing the client identifier fails validation
+	delete(rep.OptionMap, OptionClientID)
+	assert.Error(t, rep.Validate())
+}
+
+func TestNakFieldValidation(t *testing.T) {
+	msg := NewPacket(BootRequest)
+
+	rep := CreateNak(&msg)
+	rep.SetOption(OptionDHCPServerID, []byte("foo"))
+	rep.SetCIAddr(net.IP{10, 0, 0, 10})
+	rep.SetYIAddr(net.IP{10, 0, 0, 10})
+	rep.SetSIAddr(net.IP{10, 0, 0, 1})
+
+	err := rep.Validate()
+	if assert.IsType(t, ValidationErrors{}, err) {
+		assert.Len(t, err, 3)
+	}
+}
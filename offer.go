@@ -1,13 +1,14 @@
 package dhcp4
 
-import "encoding/binary"
-
 // Offer is a server to client packet in response to DHCPDISCOVER with
 // offer of configuration parameters.
 type Offer struct {
 	Packet
 
 	msg *Packet
+
+	// Whether the client identifier is echoed (RFC6842)
+	echoClientID bool
 }
 
 func CreateOffer(msg *Packet) Offer {
@@ -16,11 +17,17 @@ func CreateOffer(msg *Packet) Offer {
 		msg:    msg,
 	}
 
+	rep.echoClientID = copyClientID(&rep.Packet, msg)
 	rep.SetMessageType(MessageTypeOffer)
 	return rep
 }
 
 // From RFC2131, table 3:
+//   Field      DHCPOFFER
+//   -----      ---------
+//   'ciaddr'   0
+//   'yiaddr'   IP address offered to client
+//
 //   Option                    DHCPOFFER
 //   ------                    ---------
 //   Requested IP address      MUST NOT
@@ -29,34 +36,38 @@ func CreateOffer(msg *Packet) Offer {
 //   DHCP message type         DHCPOFFER
 //   Parameter request list    MUST NOT
 //   Message                   SHOULD
-//   Client identifier         MUST NOT
+//   Client identifier         MUST NOT (MUST echo, per RFC6842)
 //   Vendor class identifier   MAY
 //   Server identifier         MUST
 //   Maximum message size      MUST NOT
 //   All others                MAY
 
 var dhcpOfferValidation = []Validation{
+	ValidateFieldZero(FieldCIAddr),
+	ValidateFieldSet(FieldYIAddr),
 	ValidateMustNot(OptionAddressRequest),
 	ValidateMust(OptionAddressTime),
 	ValidateMustNot(OptionParameterList),
-	ValidateMustNot(OptionClientID),
 	ValidateMust(OptionDHCPServerID),
 	ValidateMustNot(OptionDHCPMaxMsgSize),
+	ValidateLeaseTimes(),
+	ValidateSubnet(),
 }
 
 func (d *Offer) Validate() error {
-	return Validate(d.Packet, dhcpOfferValidation)
+	vs := append([]Validation{clientIDValidation(d.msg, d.echoClientID)}, dhcpOfferValidation...)
+	return Validate(d.Packet, vs)
 }
 
 func (d *Offer) ToBytes() ([]byte, error) {
-	opts := packetToBytesOptions{}
-
-	// Copy MaxMsgSize if set in the request
-	if v, ok := d.Message().GetOption(OptionDHCPMaxMsgSize); ok {
-		opts.maxLen = binary.BigEndian.Uint16(v)
-	}
+	return d.AppendTo(nil)
+}
 
-	return PacketToBytes(d.Packet, &opts)
+// AppendTo appends the wire-level representation of the reply to dst and
+// returns the extended buffer.
+func (d *Offer) AppendTo(dst []byte) ([]byte, error) {
+	opts := replyOptions(d.Message())
+	return appendPacket(dst, d.Packet, &opts)
 }
 
 func (d *Offer) Message() *Packet {
@@ -1,15 +1,22 @@
 package dhcp4
 
-import "testing"
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
 
 func TestOfferValidation(t *testing.T) {
 	testCase := replyValidationTestCase{
 		newReply: func() ValidatingReply {
 			msg := NewPacket(BootRequest)
-			return &Offer{
+			rep := Offer{
 				Packet: NewPacket(BootReply),
 				msg:    &msg,
 			}
+			rep.SetYIAddr(net.IP{10, 0, 0, 10})
+			return &rep
 		},
 		must: []Option{
 			OptionAddressTime,
@@ -25,3 +32,43 @@ func TestOfferValidation(t *testing.T) {
 
 	testCase.Test(t)
 }
+
+func TestOfferClientIDEcho(t *testing.T) {
+	msg := NewPacket(BootRequest)
+	msg.SetOption(OptionClientID, []byte("\x00foo"))
+
+	rep := CreateOffer(&msg)
+	rep.SetYIAddr(net.IP{10, 0, 0, 10})
+	rep.SetOption(OptionAddressTime, []byte("foo"))
+	rep.SetOption(OptionDHCPServerID, []byte("foo"))
+
+	v, ok := rep.GetOption(OptionClientID)
+	if assert.True(t, ok) {
+		assert.Equal(t, []byte("\x00foo"), v)
+	}
+	assert.NoError(t, rep.Validate())
+
+	// Altering the client identifier fails validation
+	rep.SetOption(OptionClientID, []byte("\x00bar"))
+	assert.Error(t, rep.Validate())
+}
+
+func TestOfferClientIDStrict(t *testing.T) {
+	msg := NewPacket(BootRequest)
+	msg.SetOption(OptionClientID, []byte("\x00foo"))
+
+	msg.noClientIDEcho = true
+
+	rep := CreateOffer(&msg)
+	rep.SetYIAddr(net.IP{10, 0, 0, 10})
+	rep.SetOption(OptionAddressTime, []byte("foo"))
+	rep.SetOption(OptionDHCPServerID, []byte("foo"))
+
+	_, ok := rep.GetOption(OptionClientID)
+	assert.False(t, ok)
+	assert.NoError(t, rep.Validate())
+
+	// The client identifier MUST NOT be set
+	rep.SetOption(OptionClientID, []byte("\x00foo"))
+	assert.Error(t, rep.Validate())
+}
@@ -8,6 +8,7 @@ import (
 	"reflect"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -101,6 +102,47 @@ func (om OptionMap) GetSortedOptions() []Option {
 	return []Option(ks)
 }
 
+// GetOrderedOptions gets all the options (keys), with the options in order
+// first (in that order) and the remainder in sorted order.
+func (om OptionMap) GetOrderedOptions(order []Option) []Option {
+	ks := make([]Option, 0, len(om))
+	seen := make(map[Option]bool, len(order))
+	for _, k := range order {
+		if _, ok := om[k]; ok && !seen[k] {
+			ks = append(ks, k)
+			seen[k] = true
+		}
+	}
+	for _, k := range om.GetSortedOptions() {
+		if !seen[k] {
+			ks = append(ks, k)
+		}
+	}
+	return ks
+}
+
+// appendOrderedOptions appends the options (keys) to dst in the same order as
+// GetOrderedOptions, with order being the value of a Parameter Request List
+// option. It doesn't allocate if dst has enough capacity.
+func (om OptionMap) appendOrderedOptions(dst []Option, order []byte) []Option {
+	var present, seen [256]bool
+	for k := range om {
+		present[k] = true
+	}
+	for _, k := range order {
+		if present[k] && !seen[k] {
+			dst = append(dst, Option(k))
+			seen[k] = true
+		}
+	}
+	for k := range present {
+		if present[k] && !seen[k] {
+			dst = append(dst, Option(k))
+		}
+	}
+	return dst
+}
+
 // GetOption gets the []byte value of an option.
 func (om OptionMap) GetOption(o Option) ([]byte, bool) {
 	v, ok := om[o]
@@ -127,6 +169,22 @@ func (om OptionMap) SetMessageType(m MessageType) {
 	om.SetOption(OptionDHCPMsgType, []byte{byte(m)})
 }
 
+// GetParameterRequestList gets the options listed in the Parameter Request
+// List option field, in the order the client listed them.
+func (om OptionMap) GetParameterRequestList() ([]Option, bool) {
+	v, ok := om.GetOption(OptionParameterList)
+	if !ok {
+		return nil, false
+	}
+
+	os := make([]Option, len(v))
+	for i, o := range v {
+		os[i] = Option(o)
+	}
+
+	return os, true
+}
+
 // GetUint8 gets the 8 bit unsigned integer value of an option.
 func (om OptionMap) GetUint8(o Option) (uint8, bool) {
 	if v, ok := om.GetOption(o); ok && len(v) == 1 {
@@ -297,168 +355,499 @@ func (om OptionMap) Serialize() []byte {
 	return b.Bytes()
 }
 
-func (om OptionMap) decodeValue(code int, dv reflect.Value) {
-	var rv reflect.Value
+// FieldError describes a struct field that cannot be encoded to or decoded
+// from its option.
+type FieldError struct {
+	Field  string
+	Option Option
+	Reason string
+}
 
-	dt := dv.Type()
-	n := 0
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("dhcp4: field %s (option %d): %s", e.Field, e.Option, e.Reason)
+}
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	ipType       = reflect.TypeOf(net.IP(nil))
+	ipSliceType  = reflect.TypeOf([]net.IP(nil))
+)
+
+// fieldTag is the parsed representation of a `code` struct tag.
+type fieldTag struct {
+	code     Option
+	keepZero bool
+
+	// Enterprise number for vendor-identifying options (RFC3925)
+	enterprise    uint32
+	hasEnterprise bool
+}
+
+func parseFieldTag(s string) (fieldTag, error) {
+	var t fieldTag
 
-	// Indirect the pointer type as far as needed
-	for dt.Kind() == reflect.Ptr {
-		dt = dt.Elem()
-		n++
+	parts := strings.Split(s, ",")
+	code, err := strconv.Atoi(parts[0])
+	if err != nil || code <= int(OptionPad) || code >= int(OptionEnd) {
+		return t, fmt.Errorf("invalid code %q", parts[0])
+	}
+	t.code = Option(code)
+
+	for _, p := range parts[1:] {
+		switch {
+		case p == "keepzero":
+			t.keepZero = true
+		case strings.HasPrefix(p, "enterprise="):
+			n, err := strconv.ParseUint(strings.TrimPrefix(p, "enterprise="), 10, 32)
+			if err != nil {
+				return t, fmt.Errorf("invalid enterprise number %q", p)
+			}
+			t.enterprise = uint32(n)
+			t.hasEnterprise = true
+		default:
+			return t, fmt.Errorf("unknown tag option %q", p)
+		}
 	}
 
-	switch dt.Kind() {
-	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Int8, reflect.Int16, reflect.Int32:
-		if v, ok := om.GetOption(Option(code)); ok && len(v) > 0 {
-			rv = reflect.New(dt)
-			binary.Read(bytes.NewReader(v), binary.BigEndian, rv.Interface())
+	return t, nil
+}
+
+// walkFields calls fn for every exported field of the struct value sv that has
+// a `code` tag.
+func walkFields(sv reflect.Value, fn func(fv reflect.Value, name string, t fieldTag) error) error {
+	st := sv.Type()
+	for i := 0; i < st.NumField(); i++ {
+		ft := st.Field(i)
 
-			// Dereference pointer so that the underlying value can be assigned to
-			// the destination reflect.Value if it is not a pointer.
-			rv = rv.Elem()
+		s := ft.Tag.Get("code")
+		if s == "" || ft.PkgPath != "" {
+			continue
 		}
-	case reflect.String:
-		if v, ok := om.GetString(Option(code)); ok {
-			rv = reflect.ValueOf(v)
+
+		t, err := parseFieldTag(s)
+		if err != nil {
+			return &FieldError{Field: ft.Name, Reason: err.Error()}
 		}
-	case reflect.Bool:
-		if v, ok := om.GetUint8(Option(code)); ok {
-			rv = reflect.ValueOf(v > 0)
+
+		if err := fn(sv.Field(i), ft.Name, t); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	// Abort if the result value wasn't set
-	if !rv.IsValid() {
-		return
+func intSize(k reflect.Kind) int {
+	switch k {
+	case reflect.Uint8, reflect.Int8:
+		return 1
+	case reflect.Uint16, reflect.Int16:
+		return 2
+	case reflect.Uint32, reflect.Int32:
+		return 4
 	}
+	return 0
+}
 
-	// Get the value to the pointer depth to the level we can set it at
-	for ; n > 0; n-- {
-		if rv.CanAddr() {
-			rv = rv.Addr()
-		} else {
-			// Make addressable
-			x := reflect.New(rv.Type())
-			x.Elem().Set(rv)
-			rv = x
-		}
+func getInt(b []byte, v reflect.Value) {
+	var u uint64
+	switch len(b) {
+	case 1:
+		u = uint64(b[0])
+	case 2:
+		u = uint64(binary.BigEndian.Uint16(b))
+	case 4:
+		u = uint64(binary.BigEndian.Uint32(b))
 	}
 
-	dv.Set(rv)
+	switch v.Kind() {
+	case reflect.Int8:
+		v.SetInt(int64(int8(u)))
+	case reflect.Int16:
+		v.SetInt(int64(int16(u)))
+	case reflect.Int32:
+		v.SetInt(int64(int32(u)))
+	default:
+		v.SetUint(u)
+	}
 }
 
-func (om OptionMap) Decode(dst interface{}) {
-	sv := reflect.ValueOf(dst)
-	st := sv.Type()
-
-	// Expect a pointer
-	if st.Kind() != reflect.Ptr {
-		panic("dhcp4: expected a *struct")
+func appendInt(b []byte, v reflect.Value) []byte {
+	var u uint64
+	switch v.Kind() {
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		u = uint64(v.Int())
+	default:
+		u = v.Uint()
 	}
 
-	sv = sv.Elem()
-	st = sv.Type()
+	switch intSize(v.Kind()) {
+	case 1:
+		return append(b, byte(u))
+	case 2:
+		return append(b, byte(u>>8), byte(u))
+	default:
+		return append(b, byte(u>>24), byte(u>>16), byte(u>>8), byte(u))
+	}
+}
 
-	// Expect a struct
-	if st.Kind() != reflect.Struct {
-		panic("dhcp4: expected a *struct")
+// findEnterprise returns the data for the specified enterprise number in the
+// value of a vendor-identifying option (RFC3925, section 4).
+func findEnterprise(b []byte, enterprise uint32) ([]byte, bool) {
+	for len(b) >= 5 {
+		n := binary.BigEndian.Uint32(b[0:4])
+		l := int(b[4])
+		if len(b) < 5+l {
+			break
+		}
+		if n == enterprise {
+			return b[5 : 5+l], true
+		}
+		b = b[5+l:]
 	}
+	return nil, false
+}
 
-	// Walk the fields of this struct
-	n := sv.NumField()
-	for i := 0; i < n; i++ {
-		fv := sv.Field(i)
-		ft := st.Field(i)
+// decodeBytes decodes the option value b into v, which must be settable and
+// not be a pointer.
+func decodeBytes(b []byte, v reflect.Value, t fieldTag) error {
+	switch v.Type() {
+	case durationType:
+		if len(b) != 4 {
+			return fmt.Errorf("expected 4 bytes, got %d", len(b))
+		}
+		v.SetInt(int64(time.Duration(binary.BigEndian.Uint32(b)) * time.Second))
+		return nil
+	case ipType:
+		if len(b) != 4 {
+			return fmt.Errorf("expected 4 bytes, got %d", len(b))
+		}
+		v.Set(reflect.ValueOf(net.IPv4(b[0], b[1], b[2], b[3])))
+		return nil
+	case ipSliceType:
+		if len(b) == 0 || len(b)%4 != 0 {
+			return fmt.Errorf("expected a multiple of 4 bytes, got %d", len(b))
+		}
+		ips := make([]net.IP, 0, len(b)/4)
+		for i := 0; i < len(b); i += 4 {
+			ips = append(ips, net.IPv4(b[i], b[i+1], b[i+2], b[i+3]))
+		}
+		v.Set(reflect.ValueOf(ips))
+		return nil
+	}
 
-		s := ft.Tag.Get("code")
-		if s == "" {
-			continue
+	switch k := v.Kind(); k {
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Int8, reflect.Int16, reflect.Int32:
+		if n := intSize(k); len(b) != n {
+			return fmt.Errorf("expected %d bytes, got %d", n, len(b))
+		}
+		getInt(b, v)
+	case reflect.String:
+		v.SetString(string(b))
+	case reflect.Bool:
+		if len(b) != 1 {
+			return fmt.Errorf("expected 1 byte, got %d", len(b))
+		}
+		v.SetBool(b[0] > 0)
+	case reflect.Slice:
+		ek := v.Type().Elem().Kind()
+		if ek == reflect.Uint8 {
+			v.SetBytes(append([]byte(nil), b...))
+			break
 		}
 
-		code, err := strconv.Atoi(s)
-		if err != nil {
-			continue
+		n := intSize(ek)
+		if n == 0 {
+			return fmt.Errorf("unsupported type %s", v.Type())
+		}
+		if len(b)%n != 0 {
+			return fmt.Errorf("expected a multiple of %d bytes, got %d", n, len(b))
+		}
+		s := reflect.MakeSlice(v.Type(), len(b)/n, len(b)/n)
+		for i := 0; i < s.Len(); i++ {
+			getInt(b[i*n:(i+1)*n], s.Index(i))
+		}
+		v.Set(s)
+	case reflect.Struct:
+		if t.hasEnterprise {
+			var ok bool
+			if b, ok = findEnterprise(b, t.enterprise); !ok {
+				return nil
+			}
 		}
 
-		om.decodeValue(code, fv)
+		sub := make(OptionMap)
+		opts := OptionMapDeserializeOptions{IgnoreMissingEndTag: true}
+		if err := sub.Deserialize(b, &opts); err != nil {
+			return err
+		}
+		return sub.decodeStruct(v, true)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
 	}
+
+	return nil
 }
 
-func encodeInt(v reflect.Value) []byte {
-	var buffer bytes.Buffer
+// decodeStruct decodes the options of the map into the fields of struct sv.
+// If sv is a copy of the destination, copyPtrs is set so that values the copy
+// shares with the destination through pointers are copied before they are
+// written to.
+func (om OptionMap) decodeStruct(sv reflect.Value, copyPtrs bool) error {
+	return walkFields(sv, func(fv reflect.Value, name string, t fieldTag) error {
+		b, ok := om.GetOption(t.code)
+		if !ok {
+			return nil
+		}
 
-	err := binary.Write(&buffer, binary.BigEndian, v.Interface())
-	if err != nil {
-		panic(err)
+		// Decode into a new value of the underlying type, so that the
+		// destination is left untouched if decoding fails.
+		dt := fv.Type()
+		for dt.Kind() == reflect.Ptr {
+			dt = dt.Elem()
+		}
+
+		rv := reflect.New(dt).Elem()
+
+		// Start from the current value of a nested struct, so that its
+		// sub-fields without an option are left untouched as well
+		cur := fv
+		for cur.Kind() == reflect.Ptr && !cur.IsNil() {
+			cur = cur.Elem()
+		}
+		if cur.Kind() == reflect.Struct {
+			rv.Set(cur)
+		}
+
+		if err := decodeBytes(b, rv, t); err != nil {
+			if fe, ok := err.(*FieldError); ok {
+				fe.Field = name + "." + fe.Field
+				return fe
+			}
+			return &FieldError{Field: name, Option: t.code, Reason: err.Error()}
+		}
+
+		// Allocate pointers as deep as needed
+		for fv.Kind() == reflect.Ptr {
+			if fv.IsNil() || copyPtrs {
+				fv.Set(reflect.New(fv.Type().Elem()))
+			}
+			fv = fv.Elem()
+		}
+
+		fv.Set(rv)
+		return nil
+	})
+}
+
+// Decode sets the fields of the struct pointed to by dst from the options in
+// the option map, using the option codes in the `code` struct tags of its
+// fields. Fields without a `code` tag, and fields for options that are not
+// present in the map, are left untouched. This includes the fields of a struct
+// for an encapsulated option that are not present in its sub-options.
+//
+// Supported field types (and pointers to them) are 8, 16 and 32 bit integers,
+// bools, strings, []byte, slices of integers, net.IP, []net.IP,
+// time.Duration (in seconds), and structs holding the sub-options of
+// encapsulated options such as options 43 and 82. A struct for option 125 must
+// specify the enterprise number its sub-options belong to, as in
+// `code:"125,enterprise=3561"`.
+//
+// Decode returns an error if dst is not a pointer to a struct, if a field has
+// an unsupported type, or if an option value has an invalid length for the
+// field it is decoded into.
+func (om OptionMap) Decode(dst interface{}) error {
+	sv := reflect.ValueOf(dst)
+
+	// Expect a pointer to a struct
+	if sv.Kind() != reflect.Ptr || sv.IsNil() || sv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("dhcp4: expected a *struct, got %T", dst)
+	}
+
+	return om.decodeStruct(sv.Elem(), false)
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Type() {
+	case ipType:
+		return len(v.Bytes()) == 0 || v.Interface().(net.IP).IsUnspecified()
 	}
 
-	return buffer.Bytes()
+	switch v.Kind() {
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return v.Uint() == 0
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.String, reflect.Slice:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	}
+	return false
 }
 
-func (om OptionMap) encodeValue(code int, v reflect.Value) {
+// encodeValue encodes v into its option value. It returns false if there is
+// nothing to encode.
+func encodeValue(v reflect.Value, t fieldTag) ([]byte, bool, error) {
 	for v.Kind() == reflect.Ptr {
 		if v.IsNil() {
 			// Bail if there is nothing to see here...
-			return
+			return nil, false, nil
 		}
 		v = v.Elem()
 	}
 
-	switch v.Kind() {
-	case reflect.Uint8, reflect.Uint16, reflect.Uint32:
-		if v.Uint() != 0 {
-			om.SetOption(Option(code), encodeInt(v))
+	if v.Kind() == reflect.Slice && v.IsNil() {
+		return nil, false, nil
+	}
+
+	// Empty strings and slices would encode to zero-length options, which are
+	// never sent. Other zero values are only encoded when asked to.
+	if (v.Kind() == reflect.String || v.Kind() == reflect.Slice) && v.Len() == 0 {
+		return nil, false, nil
+	}
+	if !t.keepZero && isEmptyValue(v) {
+		return nil, false, nil
+	}
+
+	switch v.Type() {
+	case durationType:
+		d := v.Interface().(time.Duration)
+		if d < 0 || d/time.Second > time.Duration(^uint32(0)) {
+			return nil, false, fmt.Errorf("duration %s out of range", d)
 		}
-	case reflect.Int8, reflect.Int16, reflect.Int32:
-		if v.Int() != 0 {
-			om.SetOption(Option(code), encodeInt(v))
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, uint32(d/time.Second))
+		return b, true, nil
+	case ipType:
+		ip := v.Interface().(net.IP).To4()
+		if ip == nil {
+			return nil, false, fmt.Errorf("%s is not an IPv4 address", v.Interface())
 		}
-	case reflect.String:
-		if v.Len() > 0 {
-			om.SetOption(Option(code), []byte(v.Interface().(string)))
+		return append([]byte(nil), ip...), true, nil
+	case ipSliceType:
+		b := make([]byte, 0, 4*v.Len())
+		for _, ip := range v.Interface().([]net.IP) {
+			ip4 := ip.To4()
+			if ip4 == nil {
+				return nil, false, fmt.Errorf("%s is not an IPv4 address", ip)
+			}
+			b = append(b, ip4...)
 		}
+		return b, true, nil
+	}
+
+	switch k := v.Kind(); k {
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Int8, reflect.Int16, reflect.Int32:
+		return appendInt(nil, v), true, nil
+	case reflect.String:
+		return []byte(v.String()), true, nil
 	case reflect.Bool:
 		if v.Bool() {
-			om.SetOption(Option(code), []byte{0x1})
+			return []byte{0x1}, true, nil
+		}
+		return []byte{0x0}, true, nil
+	case reflect.Slice:
+		ek := v.Type().Elem().Kind()
+		if ek == reflect.Uint8 {
+			return append([]byte(nil), v.Bytes()...), true, nil
+		}
+		if intSize(ek) == 0 {
+			return nil, false, fmt.Errorf("unsupported type %s", v.Type())
+		}
+		b := make([]byte, 0, intSize(ek)*v.Len())
+		for i := 0; i < v.Len(); i++ {
+			b = appendInt(b, v.Index(i))
+		}
+		return b, true, nil
+	case reflect.Struct:
+		sub := make(OptionMap)
+		if err := sub.encodeStruct(v); err != nil {
+			return nil, false, err
 		}
-	}
-}
 
-func (om OptionMap) Encode(dst interface{}) {
-	sv := reflect.ValueOf(dst)
-	st := sv.Type()
+		// Encapsulated options without sub-options are omitted
+		if len(sub) == 0 {
+			return nil, false, nil
+		}
 
-	// Dereference the pointer as deep as possible
-	for sv.Kind() == reflect.Ptr {
-		sv = sv.Elem()
-		st = sv.Type()
-	}
+		var b []byte
+		for _, k := range sub.GetSortedOptions() {
+			b = append(b, byte(k), byte(len(sub[k])))
+			b = append(b, sub[k]...)
+		}
 
-	// Expect a struct
-	if st.Kind() != reflect.Struct {
-		panic("dhcp4: expected a *struct")
+		if t.hasEnterprise {
+			if len(b) > 255 {
+				return nil, false, fmt.Errorf("value of %d bytes exceeds maximum of 255", len(b))
+			}
+			h := make([]byte, 5, 5+len(b))
+			binary.BigEndian.PutUint32(h, t.enterprise)
+			h[4] = byte(len(b))
+			b = append(h, b...)
+		}
+		return b, true, nil
 	}
 
-	// Walk the fields of this struct
-	n := sv.NumField()
-	for i := 0; i < n; i++ {
-		fv := sv.Field(i)
-		ft := st.Field(i)
+	return nil, false, fmt.Errorf("unsupported type %s", v.Type())
+}
 
-		s := ft.Tag.Get("code")
-		if s == "" {
-			continue
+func (om OptionMap) encodeStruct(sv reflect.Value) error {
+	return walkFields(sv, func(fv reflect.Value, name string, t fieldTag) error {
+		b, ok, err := encodeValue(fv, t)
+		if err != nil {
+			if fe, ok := err.(*FieldError); ok {
+				fe.Field = name + "." + fe.Field
+				return fe
+			}
+			return &FieldError{Field: name, Option: t.code, Reason: err.Error()}
+		}
+		if !ok {
+			return nil
 		}
 
-		code, err := strconv.Atoi(s)
-		if err != nil {
-			continue
+		// Vendor-identifying options may carry data for multiple enterprises
+		if v, exists := om[t.code]; exists && t.hasEnterprise {
+			b = append(append([]byte(nil), v...), b...)
+		}
+
+		if len(b) > 255 {
+			return &FieldError{
+				Field:  name,
+				Option: t.code,
+				Reason: fmt.Sprintf("value of %d bytes exceeds maximum of 255", len(b)),
+			}
 		}
 
-		om.encodeValue(code, fv)
+		om.SetOption(t.code, b)
+		return nil
+	})
+}
+
+// Encode sets options in the option map from the fields of the struct (or
+// pointer to a struct) src, using the option codes in the `code` struct tags
+// of its fields. It supports the same field types as Decode.
+//
+// Nil pointers, empty strings and empty slices are never encoded. Other zero
+// values, such as 0, false and 0.0.0.0, are skipped unless the tag has the
+// keepzero option, as in `code:"19,keepzero"`. Encapsulated options without
+// sub-options are omitted.
+//
+// Encode returns an error if src is not a struct, if a field has an
+// unsupported type, or if a value cannot be represented in an option.
+func (om OptionMap) Encode(src interface{}) error {
+	sv := reflect.ValueOf(src)
+
+	// Dereference the pointer as deep as possible
+	for sv.Kind() == reflect.Ptr && !sv.IsNil() {
+		sv = sv.Elem()
+	}
+
+	// Expect a struct
+	if sv.Kind() != reflect.Struct {
+		return fmt.Errorf("dhcp4: expected a struct, got %T", src)
 	}
+
+	return om.encodeStruct(sv)
 }
 
 // From RFC2132: DHCP Options and BOOTP Vendor Extensions
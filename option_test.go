@@ -259,3 +259,223 @@ func TestOptionMapDecodeEncodeWithPtr(t *testing.T) {
 	omX.Encode(&s)
 	assert.Equal(t, om, omX)
 }
+
+func TestOptionMapParameterRequestList(t *testing.T) {
+	om := make(OptionMap)
+
+	_, ok := om.GetParameterRequestList()
+	assert.False(t, ok)
+
+	om.SetOption(OptionParameterList, []byte{3, 1, 6})
+
+	prl, ok := om.GetParameterRequestList()
+	assert.True(t, ok)
+	assert.Equal(t, []Option{OptionRouter, OptionSubnetMask, OptionDomainServer}, prl)
+}
+
+func TestOptionMapGetOrderedOptions(t *testing.T) {
+	om := make(OptionMap)
+	for _, o := range []Option{1, 3, 6, 15, 51} {
+		om.SetOption(o, []byte("foo"))
+	}
+
+	assert.Equal(t, []Option{1, 3, 6, 15, 51}, om.GetOrderedOptions(nil))
+	assert.Equal(t, []Option{15, 3, 1, 6, 51}, om.GetOrderedOptions([]Option{15, 12, 3, 15, 1}))
+}
+
+func TestOptionMapDecodeEncodeExtendedTypes(t *testing.T) {
+	om := make(OptionMap)
+
+	type relayAgentInfo struct {
+		CircuitID []byte `code:"1"`
+		RemoteID  string `code:"2"`
+	}
+
+	type vendorOptions struct {
+		Port  uint16 `code:"1"`
+		Flags uint8  `code:"2"`
+	}
+
+	var s struct {
+		Mask      net.IP           `code:"1"`
+		Routers   []net.IP         `code:"3"`
+		Lease     time.Duration    `code:"51"`
+		NDI       []byte           `code:"94"`
+		Sizes     []uint16         `code:"25"`
+		Offsets   []int32          `code:"2"`
+		MAC       net.HardwareAddr `code:"224"`
+		Relay     *relayAgentInfo  `code:"82"`
+		VI        vendorOptions    `code:"125,enterprise=3561"`
+		VIOther   vendorOptions    `code:"125,enterprise=4491"`
+		Forward   bool             `code:"19,keepzero"`
+		Untouched string
+	}
+
+	om.SetOption(OptionSubnetMask, []byte{255, 255, 255, 0})
+	om.SetOption(OptionRouter, []byte{10, 0, 0, 1, 10, 0, 0, 2})
+	om.SetOption(OptionAddressTime, []byte{0, 0, 0x0e, 0x10})
+	om.SetOption(OptionClientNDI, []byte{1, 2, 1})
+	om.SetOption(OptionMTUPlateau, []byte{0x05, 0xdc, 0x02, 0x40})
+	om.SetOption(OptionTimeOffset, []byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 1})
+	om.SetOption(Option(224), []byte{0, 0x11, 0x22, 0x33, 0x44, 0x55})
+	om.SetOption(OptionRelayAgentInformation, []byte{1, 2, 'e', '0', 2, 3, 'f', 'o', 'o'})
+	om.SetOption(OptionVIVendorSpecificInformation, []byte{
+		0, 0, 0x0d, 0xe9, 7, 1, 2, 0x1f, 0x90, 2, 1, 3,
+		0, 0, 0x11, 0x8b, 4, 1, 2, 0, 80,
+	})
+	om.SetOption(OptionForwardOnOff, []byte{0})
+
+	if !assert.NoError(t, om.Decode(&s)) {
+		return
+	}
+
+	assert.Equal(t, net.IPv4(255, 255, 255, 0), s.Mask)
+	assert.Equal(t, []net.IP{net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2)}, s.Routers)
+	assert.Equal(t, time.Hour, s.Lease)
+	assert.Equal(t, []byte{1, 2, 1}, s.NDI)
+	assert.Equal(t, []uint16{1500, 576}, s.Sizes)
+	assert.Equal(t, []int32{-1, 1}, s.Offsets)
+	assert.Equal(t, net.HardwareAddr{0, 0x11, 0x22, 0x33, 0x44, 0x55}, s.MAC)
+	if assert.NotNil(t, s.Relay) {
+		assert.Equal(t, []byte("e0"), s.Relay.CircuitID)
+		assert.Equal(t, "foo", s.Relay.RemoteID)
+	}
+	assert.Equal(t, vendorOptions{Port: 8080, Flags: 3}, s.VI)
+	assert.Equal(t, vendorOptions{Port: 80}, s.VIOther)
+	assert.False(t, s.Forward)
+
+	omX := make(OptionMap)
+	if assert.NoError(t, omX.Encode(&s)) {
+		assert.Equal(t, om, omX)
+	}
+}
+
+func TestOptionMapDecodeNestedUntouched(t *testing.T) {
+	type link struct {
+		Port uint16 `code:"2"`
+	}
+
+	type relayAgentInfo struct {
+		Link      *link  `code:"3"`
+		CircuitID string `code:"1"`
+		RemoteID  string `code:"2"`
+		Flags     uint8  `code:"4"`
+	}
+
+	var s struct {
+		Relay  relayAgentInfo  `code:"82"`
+		RelayP *relayAgentInfo `code:"83"`
+	}
+	s.Relay = relayAgentInfo{CircuitID: "e0", RemoteID: "foo"}
+	s.RelayP = &relayAgentInfo{CircuitID: "e1", Link: &link{Port: 1}}
+	l := s.RelayP.Link
+
+	// Sub-fields without a sub-option keep their value
+	om := make(OptionMap)
+	om.SetOption(Option(82), []byte{2, 3, 'b', 'a', 'r'})
+	om.SetOption(Option(83), []byte{2, 3, 'b', 'a', 'z'})
+	if assert.NoError(t, om.Decode(&s)) {
+		assert.Equal(t, relayAgentInfo{CircuitID: "e0", RemoteID: "bar"}, s.Relay)
+		assert.Equal(t, &relayAgentInfo{CircuitID: "e1", RemoteID: "baz", Link: &link{Port: 1}}, s.RelayP)
+	}
+
+	// The destination is left untouched if decoding a sub-option fails,
+	// including the values it points to
+	om.SetOption(Option(83), []byte{3, 4, 2, 2, 0, 2, 1, 1, 'x', 4, 2, 0, 0})
+	assert.Error(t, om.Decode(&s))
+	assert.Equal(t, "e1", s.RelayP.CircuitID)
+	assert.True(t, l == s.RelayP.Link)
+	assert.Equal(t, uint16(1), l.Port)
+}
+
+func TestOptionMapEncodeZeroValues(t *testing.T) {
+	var s struct {
+		A uint8         `code:"10,keepzero"`
+		B uint8         `code:"11"`
+		C bool          `code:"12,keepzero"`
+		D bool          `code:"13"`
+		E net.IP        `code:"14"`
+		F time.Duration `code:"15"`
+		G string        `code:"16"`
+		H []net.IP      `code:"17"`
+		I *uint32       `code:"18"`
+		J struct {
+			X uint8 `code:"1"`
+		} `code:"19"`
+		K string        `code:"20,keepzero"`
+		L []byte        `code:"21,keepzero"`
+		M time.Duration `code:"22,keepzero"`
+		N net.IP        `code:"23,keepzero"`
+	}
+
+	s.E = net.IPv4zero
+	s.L = []byte{}
+	s.N = net.IPv4zero
+
+	// Empty strings and slices are skipped even with keepzero
+	om := make(OptionMap)
+	if assert.NoError(t, om.Encode(s)) {
+		assert.Equal(t, OptionMap{
+			Option(10): []byte{0},
+			Option(12): []byte{0},
+			Option(22): []byte{0, 0, 0, 0},
+			Option(23): []byte{0, 0, 0, 0},
+		}, om)
+	}
+}
+
+func TestOptionMapDecodeErrors(t *testing.T) {
+	var s struct {
+		IP net.IP `code:"1"`
+	}
+
+	om := make(OptionMap)
+	om.SetOption(OptionSubnetMask, []byte{255, 255, 255})
+
+	// Invalid length
+	err := om.Decode(&s)
+	if assert.IsType(t, &FieldError{}, err) {
+		fe := err.(*FieldError)
+		assert.Equal(t, "IP", fe.Field)
+		assert.Equal(t, OptionSubnetMask, fe.Option)
+	}
+	assert.Nil(t, s.IP)
+
+	// Not a pointer to a struct
+	assert.Error(t, om.Decode(s))
+	assert.Error(t, om.Decode(nil))
+	assert.Error(t, om.Decode(new(int)))
+
+	// Unsupported type
+	var u struct {
+		M map[string]string `code:"1"`
+	}
+	assert.IsType(t, &FieldError{}, om.Decode(&u))
+
+	// Invalid tag
+	var v struct {
+		X uint8 `code:"foo"`
+	}
+	assert.IsType(t, &FieldError{}, om.Decode(&v))
+}
+
+func TestOptionMapEncodeErrors(t *testing.T) {
+	// Not a struct
+	om := make(OptionMap)
+	assert.Error(t, om.Encode(nil))
+	assert.Error(t, om.Encode(1))
+
+	// Not an IPv4 address
+	var s struct {
+		IP net.IP `code:"1"`
+	}
+	s.IP = net.ParseIP("::1")
+	assert.IsType(t, &FieldError{}, om.Encode(&s))
+
+	// Too long
+	var u struct {
+		B []byte `code:"1"`
+	}
+	u.B = make([]byte, 256)
+	assert.IsType(t, &FieldError{}, om.Encode(&u))
+}
@@ -0,0 +1,167 @@
+package dhcp4
+
+// OptionIndex locates the options of a packet in its raw bytes. It is a
+// fixed-size table rather than a map, so that packets can be inspected
+// without allocating. The values it returns refer to the bytes of the packet,
+// and are only valid as long as those are.
+//
+// Like OptionMap, it keeps the last value of options that appear more than
+// once.
+type OptionIndex struct {
+	p RawPacket
+
+	// Offset of the value of every option in p plus one, or zero if absent
+	off [256]uint32
+	len [256]uint8
+
+	n int
+}
+
+// Parse indexes the options of p, including the ones overloaded into the
+// 'file' and 'sname' fields. It returns the same errors as PacketFromBytes,
+// and leaves the index empty if it fails.
+func (x *OptionIndex) Parse(p RawPacket) error {
+	*x = OptionIndex{p: p}
+
+	if err := x.parsePacket(); err != nil {
+		*x = OptionIndex{}
+		return err
+	}
+	return nil
+}
+
+func (x *OptionIndex) parsePacket() error {
+	if len(x.p) < 240 {
+		return ErrShortPacket
+	}
+
+	if err := x.parse(240, len(x.p)); err != nil {
+		return err
+	}
+
+	// Parse options from `file` field if necessary
+	if v, ok := x.GetOption(OptionOverload); ok && len(v) > 0 && v[0]&0x1 != 0 {
+		if err := x.parse(108, 236); err != nil {
+			return err
+		}
+	}
+
+	// Parse options from `sname` field if necessary
+	if v, ok := x.GetOption(OptionOverload); ok && len(v) > 0 && v[0]&0x2 != 0 {
+		if err := x.parse(44, 108); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parse indexes the options in p[i:end], which must end with OptionEnd.
+func (x *OptionIndex) parse(i, end int) error {
+	for {
+		if i >= end {
+			return ErrShortPacket
+		}
+
+		tag := Option(x.p[i])
+		i++
+		if tag == OptionEnd {
+			return nil
+		}
+
+		// Padding tag
+		if tag == OptionPad {
+			continue
+		}
+
+		// Read length octet
+		if i >= end {
+			return ErrShortPacket
+		}
+
+		length := int(x.p[i])
+		i++
+		if end-i < length {
+			return ErrShortPacket
+		}
+
+		if x.off[tag] == 0 {
+			x.n++
+		}
+		x.off[tag] = uint32(i + 1)
+		x.len[tag] = uint8(length)
+		i += length
+	}
+}
+
+// Len returns the number of options in the packet.
+func (x *OptionIndex) Len() int {
+	return x.n
+}
+
+// GetOption gets the []byte value of an option.
+func (x *OptionIndex) GetOption(o Option) ([]byte, bool) {
+	off := int(x.off[o])
+	if off == 0 {
+		return nil, false
+	}
+
+	i, j := off-1, off-1+int(x.len[o])
+	return x.p[i:j:j], true
+}
+
+// GetMessageType gets the message type from the DHCPMsgType option field.
+func (x *OptionIndex) GetMessageType() MessageType {
+	v, ok := x.GetOption(OptionDHCPMsgType)
+	if !ok || len(v) != 1 {
+		return MessageType(0)
+	}
+
+	return MessageType(v[0])
+}
+
+// AppendOptions appends the options in the packet to dst, in numeric order,
+// and returns the extended slice.
+func (x *OptionIndex) AppendOptions(dst []Option) []Option {
+	for o := range x.off {
+		if x.off[o] != 0 {
+			dst = append(dst, Option(o))
+		}
+	}
+	return dst
+}
+
+// Packet returns a Packet holding a copy of the indexed packet.
+func (x *OptionIndex) Packet() Packet {
+	p := Packet{OptionMap: make(OptionMap, x.n)}
+	x.copyTo(&p)
+	return p
+}
+
+// copyTo sets p to a copy of the indexed packet, reusing the memory of its
+// RawPacket and OptionMap, which must not be nil.
+func (x *OptionIndex) copyTo(p *Packet) {
+	p.RawPacket = append(p.RawPacket[:0], x.p...)
+
+	clear(p.OptionMap)
+	x.fillOptionMap(p.OptionMap, p.RawPacket)
+}
+
+// optionMap returns an OptionMap with the indexed options, whose values refer
+// to p instead of the indexed packet. p must hold the same bytes.
+func (x *OptionIndex) optionMap(p RawPacket) OptionMap {
+	om := make(OptionMap, x.n)
+	x.fillOptionMap(om, p)
+	return om
+}
+
+// fillOptionMap sets the indexed options in om, with values referring to p.
+// p must hold the same bytes as the indexed packet.
+func (x *OptionIndex) fillOptionMap(om OptionMap, p RawPacket) {
+	for o := range x.off {
+		if off := int(x.off[o]); off != 0 {
+			i, j := off-1, off-1+int(x.len[o])
+			om[Option(o)] = p[i:j:j]
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package dhcp4
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOptionIndex(t *testing.T) {
+	p := new(testPacket)
+	p.appendToOption(OptionDHCPMsgType, []byte{byte(MessageTypeDiscover)})
+	p.appendToOption(OptionSubnetMask, []byte{0x12})
+	p.appendToOption(OptionPad, nil)
+	p.appendToOption(OptionOverload, []byte{0x3})
+	p.appendToOption(OptionSubnetMask, []byte{0x13})
+	p.appendToOption(OptionEnd, nil)
+	p.appendToFile(OptionTimeOffset, []byte{0x34})
+	p.appendToFile(OptionEnd, nil)
+	p.appendToSName(OptionRouter, []byte{})
+	p.appendToSName(OptionEnd, nil)
+
+	var x OptionIndex
+	if !assert.NoError(t, x.Parse(p.buf)) {
+		return
+	}
+
+	assert.Equal(t, 5, x.Len())
+	assert.Equal(t, MessageTypeDiscover, x.GetMessageType())
+	assert.Equal(t, []Option{OptionSubnetMask, OptionTimeOffset, OptionRouter, OptionOverload, OptionDHCPMsgType},
+		x.AppendOptions(nil))
+
+	// The last value of duplicate options is kept
+	v, ok := x.GetOption(OptionSubnetMask)
+	assert.True(t, ok)
+	assert.Equal(t, []byte{0x13}, v)
+
+	v, ok = x.GetOption(OptionRouter)
+	assert.True(t, ok)
+	assert.Equal(t, []byte{}, v)
+
+	_, ok = x.GetOption(OptionDomainServer)
+	assert.False(t, ok)
+
+	// Values cannot be appended to in place
+	v, _ = x.GetOption(OptionTimeOffset)
+	assert.Equal(t, 1, cap(v))
+
+	// The packet is the same as the one PacketFromBytes returns
+	q, err := PacketFromBytes(p.buf)
+	if assert.NoError(t, err) {
+		assert.Equal(t, q, x.Packet())
+	}
+}
+
+func TestOptionIndexErrors(t *testing.T) {
+	var x OptionIndex
+	assert.Equal(t, ErrShortPacket, x.Parse(make([]byte, 239)))
+
+	// Missing end tag
+	assert.Equal(t, ErrShortPacket, x.Parse(make([]byte, 240)))
+
+	// Truncated option
+	p := new(testPacket)
+	p.appendToOption(OptionSubnetMask, []byte{1, 2, 3, 4})
+	assert.Equal(t, ErrShortPacket, x.Parse(p.buf[:len(p.buf)-1]))
+
+	// Missing end tag in `file` field
+	p = new(testPacket)
+	p.appendToOption(OptionOverload, []byte{0x1})
+	p.appendToOption(OptionEnd, nil)
+	assert.Equal(t, ErrShortPacket, x.Parse(p.buf))
+
+	// The index is reset by a failed parse
+	assert.Equal(t, 0, x.Len())
+}
+
+func TestOptionIndexAllocs(t *testing.T) {
+	p := NewPacket(BootRequest)
+	p.SetMessageType(MessageTypeRequest)
+	p.SetOption(OptionClientID, []byte{1, 0, 1, 2, 3, 4, 5})
+	p.SetOption(OptionParameterList, []byte{1, 3, 6, 15})
+	b, err := PacketToBytes(p, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		var x OptionIndex
+		x.Parse(b)
+		x.GetOption(OptionClientID)
+		x.GetMessageType()
+	})
+	assert.Equal(t, 0.0, allocs)
+}
+
+func BenchmarkOptionIndexParse(b *testing.B) {
+	buf := newRelayedDiscover(b, 1)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var x OptionIndex
+		if err := x.Parse(buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPacketFromBytes(b *testing.B) {
+	buf := newRelayedDiscover(b, 1)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := PacketFromBytes(buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestOptionIndexCopyToAllocs(t *testing.T) {
+	b := newRelayedDiscover(t, 1)
+
+	var x OptionIndex
+	if !assert.NoError(t, x.Parse(b)) {
+		return
+	}
+
+	// Reusing a packet doesn't allocate, unlike Packet
+	p := x.Packet()
+	allocs := testing.AllocsPerRun(100, func() {
+		x.copyTo(&p)
+	})
+	assert.Equal(t, 0.0, allocs)
+
+	expected, _ := PacketFromBytes(b)
+	assert.Equal(t, expected, p)
+}
@@ -1,8 +1,10 @@
 package dhcp4
 
 import (
+	"encoding/binary"
 	"errors"
 	"net"
+	"slices"
 )
 
 var (
@@ -173,36 +175,20 @@ func (p RawPacket) SetGIAddr(ip net.IP) {
 }
 
 func (p RawPacket) ParseOptions() (OptionMap, error) {
-	var err error
-
-	// Facilitate up to 255 option tags
-	opts := make(OptionMap, 255)
-
-	// Parse initial set of options
-	if err = opts.Deserialize(p.Options(), nil); err != nil {
+	var x OptionIndex
+	if err := x.Parse(p); err != nil {
 		return nil, err
 	}
 
-	// Parse options from `file` field if necessary
-	if x := opts[OptionOverload]; len(x) > 0 && x[0]&0x1 != 0 {
-		if err = opts.Deserialize(p.File(), nil); err != nil {
-			return nil, err
-		}
-	}
-
-	// Parse options from `sname` field if necessary
-	if x := opts[OptionOverload]; len(x) > 0 && x[0]&0x2 != 0 {
-		if err = opts.Deserialize(p.SName(), nil); err != nil {
-			return nil, err
-		}
-	}
-
-	return opts, nil
+	return x.optionMap(p), nil
 }
 
 type Packet struct {
 	RawPacket
 	OptionMap
+
+	// Set on requests read by a Server with DisableClientIDEcho
+	noClientIDEcho bool
 }
 
 // NewPacket creates and returns a new packet with the specified OpCode.
@@ -245,148 +231,180 @@ func NewReply(msg PacketGetter) Packet {
 // error if the packet is malformed. The contents of []byte b is copied into
 // the resulting structure and can be reused after this function has returned.
 func PacketFromBytes(b []byte) (Packet, error) {
-	var err error
-
-	if len(b) < 240 {
-		return Packet{}, ErrShortPacket
-	}
-
-	p := Packet{
-		RawPacket: make(RawPacket, len(b)),
-	}
-
-	copy(p.RawPacket, b)
-
-	p.OptionMap, err = p.ParseOptions()
-	if err != nil {
+	var x OptionIndex
+	if err := x.Parse(b); err != nil {
 		return Packet{}, err
 	}
 
-	return p, nil
+	return x.Packet(), nil
 }
 
 type packetToBytesOptions struct {
 	maxLen    uint16
+	order     []byte
 	skipFile  bool
 	skipSName bool
 }
 
+// replyOptions returns the options to serialize a reply to msg with: the
+// maximum message size of the client, and the order of its Parameter Request
+// List.
+func replyOptions(msg *Packet) packetToBytesOptions {
+	opts := packetToBytesOptions{}
+
+	// Copy MaxMsgSize if set in the request
+	if v, ok := msg.GetOption(OptionDHCPMaxMsgSize); ok && len(v) == 2 {
+		opts.maxLen = binary.BigEndian.Uint16(v)
+	}
+
+	// Write requested options in the order the client requested them
+	opts.order, _ = msg.GetOption(OptionParameterList)
+
+	return opts
+}
+
 // PacketToBytes serializes the DHCP packet pointed to by p into its wire-level
 // representation. The function may return an error if it cannot successfully
 // serialize the packet. Otherwise, it returns a newly created byte slice.
 func PacketToBytes(p Packet, opts *packetToBytesOptions) ([]byte, error) {
-	if len(p.RawPacket) < 240 {
-		return nil, ErrInvalidPacket
-	}
-
-	// Maximum byte length of serialized packet (default is Ethernet MTU).
-	var maxLen uint16 = 1500
-
-	// The mininum "Maximum DHCP Message Size" is 576 (RFC2132, 9.10).
-	if opts != nil && opts.maxLen > 576 {
-		maxLen = opts.maxLen
-	}
+	return appendPacket(nil, p, opts)
+}
 
-	// Buffers we can stash options in
-	var b [3][]byte
+// AppendPacket appends the wire-level representation of p to dst and returns
+// the extended buffer. It is the same as PacketToBytes, except that it doesn't
+// allocate if dst has enough capacity.
+func AppendPacket(dst []byte, p Packet) ([]byte, error) {
+	return appendPacket(dst, p, nil)
+}
 
+// Free space for options in each of the buffers options are written to,
+// after the end tag and, in the options field, the overload option.
+func optionSpace(maxLen uint16, opts *packetToBytesOptions) [3]int {
 	// Variable length options field (starting at byte 240)
-	b[0] = make([]byte, 0, maxLen-240)
+	space := [3]int{int(maxLen) - 240 - 3 - 1, -1, -1}
 
 	// Fixed length "file" field (from byte 108 to byte 236)
 	if opts == nil || !opts.skipFile {
-		b[1] = make([]byte, 0, 236-108)
+		space[1] = 236 - 108 - 1
 	}
 
 	// Fixed length "sname" field (from byte 44 to byte 108)
 	if opts == nil || !opts.skipSName {
-		b[2] = make([]byte, 0, 108-44)
+		space[2] = 108 - 44 - 1
 	}
 
-	// Write options to one of the buffers.
-	// Iterate over options in numeric order.
-	for _, k := range p.GetSortedOptions() {
+	return space
+}
+
+func appendPacket(dst []byte, p Packet, opts *packetToBytesOptions) ([]byte, error) {
+	if len(p.RawPacket) < 240 {
+		return dst, ErrInvalidPacket
+	}
+
+	// Maximum byte length of serialized packet (default is Ethernet MTU).
+	var maxLen uint16 = 1500
+
+	// The mininum "Maximum DHCP Message Size" is 576 (RFC2132, 9.10).
+	if opts != nil && opts.maxLen > 576 {
+		maxLen = opts.maxLen
+	}
+
+	// Iterate over options in the specified order, if any, and numeric order
+	// otherwise.
+	var order []byte
+	if opts != nil {
+		order = opts.order
+	}
+	var buf [256]Option
+	keys := p.appendOrderedOptions(buf[:0], order)
+
+	// Assign every option to the first of the buffers with room for it: the
+	// options field, the "file" field, or the "sname" field.
+	var dsts [256]int8
+	var used [3]int
+	space := optionSpace(maxLen, opts)
+	for _, k := range keys {
 		v := p.OptionMap[k]
 		l := 2 + len(v)
 
 		// TODO(PN): Deal with DHCP options of length > 255
 		// https://www.pivotaltracker.com/story/show/68123382
+		dsts[k] = -1
 		if len(v) > 255 {
 			continue
 		}
 
-		for i := range b {
-			cb := cap(b[i])
-			lb := len(b[i])
-			f := cb - lb
-
-			// The first buffer needs to have at least 3 bytes extra for OptionOverload
-			if i == 0 {
-				f -= 3
-			}
-
-			// Every buffer needs to have at least 1 byte extra for OptionEnd
-			f--
-
-			// Check that this buffer has room for this option
-			if f < l {
-				continue
+		for i := range space {
+			if space[i]-used[i] >= l {
+				dsts[k] = int8(i)
+				used[i] += l
+				break
 			}
-
-			// Write option to buffer
-			b[i] = b[i][:lb+l]
-			b[i][lb+0] = byte(k)
-			b[i][lb+1] = byte(len(v))
-			copy(b[i][lb+2:], v)
-			break
 		}
 	}
 
-	// Add OptionEnd to the buffers that need one
-	for i := range b {
-		lb := len(b[i])
-		if i == 0 || lb > 0 {
-			b[i] = b[i][:lb+1]
-			b[i][lb] = byte(OptionEnd)
-		}
-	}
+	overload := used[1] > 0 || used[2] > 0
 
-	// Capacity: base packet, optional OptionOverload option, and options field
-	oc := 240 + 3 + len(b[0])
-	ol := 0
-	o := make([]byte, ol, oc)
+	// Base packet, optional OptionOverload option, options field and
+	// OptionEnd
+	n := 240 + used[0] + 1
+	if overload {
+		n += 3
+	}
+	dst = slices.Grow(dst, n)
 
 	// Copy base packet
-	copy(o[0:240], p.RawPacket[0:240])
-	ol = 240
+	start := len(dst)
+	dst = append(dst, p.RawPacket[0:240]...)
 
-	// Copy options overloaded into the SName and File sections
-	if len(b[1]) > 0 || len(b[2]) > 0 {
-		overload := 0x0
+	// Offsets to write the File and SName sections at; options in the
+	// options field are appended
+	offs := [3]int{0, start + 108, start + 44}
+	if overload {
+		flag := byte(0x0)
 
 		// File section
-		if len(b[1]) > 0 {
-			overload |= 0x1
-			copy(o[108:236], b[1])
+		if used[1] > 0 {
+			flag |= 0x1
 		}
 
 		// SName section
-		if len(b[2]) > 0 {
-			overload |= 0x2
-			copy(o[44:108], b[2])
+		if used[2] > 0 {
+			flag |= 0x2
 		}
 
 		// Add OptionOverload
-		o = o[:ol+3]
-		o[ol+0] = byte(OptionOverload)
-		o[ol+1] = byte(1)
-		o[ol+2] = byte(overload)
-		ol += 3
+		dst = append(dst, byte(OptionOverload), 1, flag)
 	}
 
-	// Add options
-	o = o[:ol+len(b[0])]
-	copy(o[ol:ol+len(b[0])], b[0])
+	// Write options to their buffers
+	for _, k := range keys {
+		i := dsts[k]
+		if i < 0 {
+			continue
+		}
+
+		v := p.OptionMap[k]
+		if i == 0 {
+			dst = append(dst, byte(k), byte(len(v)))
+			dst = append(dst, v...)
+			continue
+		}
+
+		o := offs[i]
+		dst[o] = byte(k)
+		dst[o+1] = byte(len(v))
+		copy(dst[o+2:], v)
+		offs[i] += 2 + len(v)
+	}
+
+	// Add OptionEnd to the buffers that need one
+	for i := 1; i < len(used); i++ {
+		if used[i] > 0 {
+			dst[offs[i]] = byte(OptionEnd)
+		}
+	}
+	dst = append(dst, byte(OptionEnd))
 
-	return o, nil
+	return dst, nil
 }
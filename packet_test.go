@@ -253,3 +253,89 @@ func TestPacketToBytes(t *testing.T) {
 		}
 	}
 }
+
+func TestAppendPacket(t *testing.T) {
+	p := NewPacket(BootReply)
+	p.SetMessageType(MessageTypeOffer)
+
+	// Fill the options field, and overload the `file` and `sname` fields
+	for o := Option(1); o <= 6; o++ {
+		p.SetOption(o, make([]byte, 200))
+	}
+	p.SetOption(Option(7), make([]byte, 100))
+	p.SetOption(Option(8), make([]byte, 50))
+
+	expected, err := PacketToBytes(p, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	prefix := []byte("prefix")
+	b, err := AppendPacket(prefix, p)
+	if assert.NoError(t, err) {
+		assert.Equal(t, append(prefix, expected...), b)
+	}
+
+	q, err := PacketFromBytes(expected)
+	if assert.NoError(t, err) {
+		assertOption(t, q.OptionMap, OptionOverload, []byte{0x3})
+		assertEqualOptionMaps(t, p.OptionMap, q.OptionMap)
+	}
+
+	_, err = AppendPacket(nil, Packet{RawPacket: make(RawPacket, 239)})
+	assert.Equal(t, ErrInvalidPacket, err)
+}
+
+func TestAppendPacketAllocs(t *testing.T) {
+	msg := NewPacket(BootRequest)
+	msg.SetMessageType(MessageTypeDiscover)
+	msg.SetOption(OptionParameterList, []byte{1, 3, 6})
+
+	offer := CreateOffer(&msg)
+	offer.SetIP(OptionSubnetMask, []byte{255, 255, 255, 0})
+	offer.SetIP(OptionRouter, []byte{10, 0, 0, 1})
+	offer.SetDuration(OptionAddressTime, 3600)
+
+	buf := make([]byte, 0, 1500)
+	allocs := testing.AllocsPerRun(100, func() {
+		buf, _ = offer.AppendTo(buf[:0])
+	})
+	assert.Equal(t, 0.0, allocs)
+
+	expected, err := offer.ToBytes()
+	if assert.NoError(t, err) {
+		assert.Equal(t, expected, buf)
+	}
+}
+
+func BenchmarkPacketToBytes(b *testing.B) {
+	p := NewPacket(BootReply)
+	p.SetMessageType(MessageTypeOffer)
+	p.SetIP(OptionSubnetMask, []byte{255, 255, 255, 0})
+	p.SetIP(OptionRouter, []byte{10, 0, 0, 1})
+	p.SetUint32(OptionAddressTime, 3600)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := PacketToBytes(p, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkAppendPacket(b *testing.B) {
+	p := NewPacket(BootReply)
+	p.SetMessageType(MessageTypeOffer)
+	p.SetIP(OptionSubnetMask, []byte{255, 255, 255, 0})
+	p.SetIP(OptionRouter, []byte{10, 0, 0, 1})
+	p.SetUint32(OptionAddressTime, 3600)
+
+	buf := make([]byte, 0, 1500)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var err error
+		if buf, err = AppendPacket(buf[:0], p); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package dhcp4
+
+import "sync"
+
+// Size of the buffers replies are serialized into. Replies are at most as
+// large as the maximum message size of the client, which is usually smaller.
+const replyBufferSize = 1500
+
+// Replies to clients with a large maximum message size grow the buffers they
+// are serialized into; buffers that grow larger than this are not reused.
+const maxReplyBufferSize = 65536
+
+var replyBuffers = sync.Pool{
+	New: func() any {
+		b := make([]byte, 0, replyBufferSize)
+		return &b
+	},
+}
+
+// getBuffer returns an empty buffer to serialize a reply into.
+func getBuffer() *[]byte {
+	return replyBuffers.Get().(*[]byte)
+}
+
+// putBuffer puts buf back in the pool. b is the result of appending to buf,
+// and replaces it if appending had to grow it.
+func putBuffer(buf *[]byte, b []byte) {
+	if cap(b) > cap(*buf) && cap(b) <= maxReplyBufferSize {
+		*buf = b[:0]
+	}
+	replyBuffers.Put(buf)
+}
+
+// Buffers to read packets into, as large as the largest UDP datagram.
+var readBuffers = sync.Pool{
+	New: func() any {
+		b := make([]byte, 65536)
+		return &b
+	},
+}
+
+// Requests that handlers are called with. They are reused once the handler
+// returns, so that the serve loop doesn't allocate a packet and an option map
+// for every request.
+var requestPackets = sync.Pool{
+	New: func() any {
+		return &Packet{
+			RawPacket: make(RawPacket, 0, replyBufferSize),
+			OptionMap: make(OptionMap),
+		}
+	},
+}
+
+// getRequest returns a copy of the packet indexed by x. Call putRequest to
+// release it.
+func getRequest(x *OptionIndex) *Packet {
+	p := requestPackets.Get().(*Packet)
+	x.copyTo(p)
+	return p
+}
+
+// putRequest puts p back in the pool. Requests that grew larger than the
+// largest reply buffer are not reused.
+func putRequest(p *Packet) {
+	if cap(p.RawPacket) > maxReplyBufferSize {
+		return
+	}
+	requestPackets.Put(p)
+}
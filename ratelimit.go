@@ -0,0 +1,209 @@
+package dhcp4
+
+import (
+	"container/list"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RateLimitKey returns the key of the token bucket a request is counted
+// against. It returns false if the request is not rate limited.
+type RateLimitKey func(p *Packet) (key string, ok bool)
+
+// HardwareAddrKey rate limits requests per client hardware address.
+func HardwareAddrKey(p *Packet) (string, bool) {
+	return string(p.GetCHAddr()), true
+}
+
+// ClientIDKey rate limits requests per client identifier. Clients without
+// option 61 are identified by their hardware address, like in GetClientID.
+func ClientIDKey(p *Packet) (string, bool) {
+	return p.GetClientID().Key(), true
+}
+
+// RelayAddrKey rate limits relayed requests per relay agent. Requests that
+// were not relayed are not rate limited.
+func RelayAddrKey(p *Packet) (string, bool) {
+	ip := p.GetGIAddr()
+	if ip.Equal(net.IPv4zero) {
+		return "", false
+	}
+	return string(ip), true
+}
+
+// CircuitIDKey rate limits relayed requests per relay agent circuit, as
+// identified by the Agent Circuit ID sub-option of option 82. Circuit IDs are
+// only unique per relay agent, so the key includes the relay agent address.
+// Requests without a circuit ID are not rate limited.
+func CircuitIDKey(p *Packet) (string, bool) {
+	info, ok := p.GetRelayAgentInfo()
+	if !ok {
+		return "", false
+	}
+
+	id, ok := info.GetOption(RelayAgentCircuitID)
+	if !ok {
+		return "", false
+	}
+
+	return string(p.GetGIAddr()) + string(id), true
+}
+
+// Default maximum number of token buckets a RateLimiter keeps.
+const defaultMaxBuckets = 10000
+
+// RateLimiter drops requests that exceed a rate, using a token bucket per key.
+// Every bucket holds up to Burst tokens, and is refilled at Rate tokens per
+// second. A request takes a token from its bucket, and is dropped if there is
+// none.
+//
+// The least recently used buckets are evicted when there are more than
+// MaxBuckets; a client whose bucket was evicted starts with a full bucket
+// again.
+type RateLimiter struct {
+	// Key returns the bucket of a request. If nil, HardwareAddrKey is used.
+	Key RateLimitKey
+
+	// Rate is the number of tokens per second every bucket is refilled with.
+	Rate float64
+
+	// Burst is the number of tokens a bucket holds. It must be positive.
+	Burst int
+
+	// MaxBuckets is the maximum number of buckets. If zero, a default of
+	// 10000 is used.
+	MaxBuckets int
+
+	// OnDrop, if set, is called for every request that is dropped.
+	OnDrop func(p *Packet, key string)
+
+	mu      sync.Mutex
+	lru     *list.List
+	buckets map[string]*list.Element
+	now     func() time.Time
+
+	dropped atomic.Uint64
+}
+
+type tokenBucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a rate limiter that allows rate requests per second
+// per key, with bursts of up to burst requests. If key is nil, HardwareAddrKey
+// is used. NewRateLimiter panics if rate is negative or burst is not
+// positive, since such a rate limiter would drop every request.
+func NewRateLimiter(key RateLimitKey, rate float64, burst int) *RateLimiter {
+	if rate < 0 {
+		panic("dhcp4: negative rate")
+	}
+	if burst <= 0 {
+		panic("dhcp4: burst must be positive")
+	}
+	if key == nil {
+		key = HardwareAddrKey
+	}
+
+	return &RateLimiter{
+		Key:   key,
+		Rate:  rate,
+		Burst: burst,
+	}
+}
+
+// Allow takes a token from the bucket of the request, and returns whether
+// there was one.
+func (l *RateLimiter) Allow(p *Packet) bool {
+	keyFn := l.Key
+	if keyFn == nil {
+		keyFn = HardwareAddrKey
+	}
+
+	key, ok := keyFn(p)
+	if !ok {
+		return true
+	}
+
+	if l.allow(key) {
+		return true
+	}
+
+	l.dropped.Add(1)
+	if l.OnDrop != nil {
+		l.OnDrop(p, key)
+	}
+	return false
+}
+
+func (l *RateLimiter) allow(key string) bool {
+	if l.Burst <= 0 {
+		panic("dhcp4: RateLimiter burst must be positive")
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.buckets == nil {
+		l.lru = list.New()
+		l.buckets = make(map[string]*list.Element)
+	}
+
+	now := time.Now()
+	if l.now != nil {
+		now = l.now()
+	}
+
+	var b *tokenBucket
+	if e, ok := l.buckets[key]; ok {
+		l.lru.MoveToFront(e)
+		b = e.Value.(*tokenBucket)
+		b.tokens += now.Sub(b.last).Seconds() * l.Rate
+		if max := float64(l.Burst); b.tokens > max {
+			b.tokens = max
+		}
+		b.last = now
+	} else {
+		b = &tokenBucket{key: key, tokens: float64(l.Burst), last: now}
+		l.buckets[key] = l.lru.PushFront(b)
+		l.evict()
+	}
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// evict removes the least recently used buckets while there are too many.
+func (l *RateLimiter) evict() {
+	max := l.MaxBuckets
+	if max <= 0 {
+		max = defaultMaxBuckets
+	}
+
+	for l.lru.Len() > max {
+		e := l.lru.Back()
+		l.lru.Remove(e)
+		delete(l.buckets, e.Value.(*tokenBucket).key)
+	}
+}
+
+// Dropped returns the number of requests that were dropped.
+func (l *RateLimiter) Dropped() uint64 {
+	return l.dropped.Load()
+}
+
+// Wrap returns a handler that calls h for the requests that are allowed. It
+// can be used as Middleware.
+func (l *RateLimiter) Wrap(h Handler) Handler {
+	return HandlerFunc(func(w ReplyWriter, p *Packet) {
+		if l.Allow(p) {
+			h.ServeDHCP(w, p)
+		}
+	})
+}
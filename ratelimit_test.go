@@ -0,0 +1,136 @@
+package dhcp4
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRateLimitPacket(mac net.HardwareAddr) *Packet {
+	p := NewPacket(BootRequest)
+	p.SetMessageType(MessageTypeDiscover)
+	p.HType()[0] = 1
+	p.HLen()[0] = 6
+	copy(p.CHAddr(), mac)
+	return &p
+}
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Unix(0, 0)
+
+	l := NewRateLimiter(HardwareAddrKey, 2, 3)
+	l.now = func() time.Time { return now }
+
+	a := newTestRateLimitPacket(net.HardwareAddr{0, 0, 0, 0, 0, 1})
+	b := newTestRateLimitPacket(net.HardwareAddr{0, 0, 0, 0, 0, 2})
+
+	// The burst is allowed
+	for i := 0; i < 3; i++ {
+		assert.True(t, l.Allow(a))
+	}
+	assert.False(t, l.Allow(a))
+
+	// Other clients have their own bucket
+	assert.True(t, l.Allow(b))
+
+	// Two tokens per second
+	now = now.Add(500 * time.Millisecond)
+	assert.True(t, l.Allow(a))
+	assert.False(t, l.Allow(a))
+
+	// The bucket does not fill beyond the burst
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		assert.True(t, l.Allow(a))
+	}
+	assert.False(t, l.Allow(a))
+
+	assert.Equal(t, uint64(3), l.Dropped())
+}
+
+func TestRateLimiterEvictsLeastRecentlyUsed(t *testing.T) {
+	l := NewRateLimiter(HardwareAddrKey, 0, 1)
+	l.MaxBuckets = 2
+
+	a := newTestRateLimitPacket(net.HardwareAddr{0, 0, 0, 0, 0, 1})
+	b := newTestRateLimitPacket(net.HardwareAddr{0, 0, 0, 0, 0, 2})
+	c := newTestRateLimitPacket(net.HardwareAddr{0, 0, 0, 0, 0, 3})
+
+	assert.True(t, l.Allow(a))
+	assert.True(t, l.Allow(b))
+	assert.False(t, l.Allow(a))
+
+	// Evicts b, which was used least recently
+	assert.True(t, l.Allow(c))
+	assert.Equal(t, 2, len(l.buckets))
+
+	assert.True(t, l.Allow(b))
+	assert.False(t, l.Allow(c))
+}
+
+func TestNewRateLimiterInvalid(t *testing.T) {
+	assert.Panics(t, func() { NewRateLimiter(HardwareAddrKey, 1, 0) })
+	assert.Panics(t, func() { NewRateLimiter(HardwareAddrKey, -1, 1) })
+
+	// Rate limits per hardware address by default
+	l := NewRateLimiter(nil, 0, 1)
+	a := newTestRateLimitPacket(net.HardwareAddr{0, 0, 0, 0, 0, 1})
+	b := newTestRateLimitPacket(net.HardwareAddr{0, 0, 0, 0, 0, 2})
+	assert.True(t, l.Allow(a))
+	assert.False(t, l.Allow(a))
+	assert.True(t, l.Allow(b))
+
+	// Also without the constructor
+	l = &RateLimiter{Burst: 1}
+	assert.True(t, l.Allow(a))
+	assert.False(t, l.Allow(a))
+
+	l = &RateLimiter{}
+	assert.Panics(t, func() { l.Allow(a) })
+}
+
+func TestRateLimitKeys(t *testing.T) {
+	p := newTestRateLimitPacket(net.HardwareAddr{0, 0, 0, 0, 0, 1})
+
+	// Not relayed
+	_, ok := RelayAddrKey(p)
+	assert.False(t, ok)
+	_, ok = CircuitIDKey(p)
+	assert.False(t, ok)
+
+	p.SetGIAddr(net.IP{10, 0, 0, 1})
+	key, ok := RelayAddrKey(p)
+	assert.True(t, ok)
+	assert.Equal(t, "\x0a\x00\x00\x01", key)
+
+	p.SetOption(OptionRelayAgentInformation, []byte{byte(RelayAgentCircuitID), 2, 'e', '0'})
+	key, ok = CircuitIDKey(p)
+	assert.True(t, ok)
+	assert.Equal(t, "\x0a\x00\x00\x01e0", key)
+
+	// Derived from chaddr without option 61
+	key, ok = ClientIDKey(p)
+	assert.True(t, ok)
+	assert.Equal(t, NewHardwareClientID(1, p.GetCHAddr()).Key(), key)
+}
+
+func TestRateLimiterWrap(t *testing.T) {
+	var calls int
+	var dropped []string
+
+	l := NewRateLimiter(HardwareAddrKey, 0, 1)
+	l.OnDrop = func(p *Packet, key string) {
+		dropped = append(dropped, key)
+	}
+
+	h := Chain(HandlerFunc(func(w ReplyWriter, p *Packet) { calls++ }), l.Wrap)
+
+	p := newTestRateLimitPacket(net.HardwareAddr{0, 0, 0, 0, 0, 1})
+	h.ServeDHCP(nil, p)
+	h.ServeDHCP(nil, p)
+
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, []string{string(p.GetCHAddr())}, dropped)
+}
@@ -0,0 +1,52 @@
+package dhcp4
+
+// Sub-options of the Relay Agent Information option (option 82). They share
+// the Option type, but are only meaningful within option 82.
+const (
+	// From RFC3046: DHCP Relay Agent Information Option
+	RelayAgentCircuitID = Option(1)
+	RelayAgentRemoteID  = Option(2)
+
+	// From RFC8357: Generalized UDP Source Port for DHCP Relay
+	RelayAgentSourcePort = Option(19)
+)
+
+// ParseRelayAgentInfo parses the value of the Relay Agent Information option
+// into a map of its sub-options.
+func ParseRelayAgentInfo(b []byte) (OptionMap, error) {
+	om := make(OptionMap)
+	if err := om.Deserialize(b, &OptionMapDeserializeOptions{IgnoreMissingEndTag: true}); err != nil {
+		return nil, err
+	}
+	return om, nil
+}
+
+// GetRelayAgentInfo gets the sub-options of the Relay Agent Information
+// option. It returns false if the option is absent or malformed.
+func (om OptionMap) GetRelayAgentInfo() (OptionMap, bool) {
+	v, ok := om.GetOption(OptionRelayAgentInformation)
+	if !ok {
+		return nil, false
+	}
+
+	info, err := ParseRelayAgentInfo(v)
+	if err != nil {
+		return nil, false
+	}
+
+	return info, true
+}
+
+// relaySourcePort returns whether the relay agent that forwarded the packet
+// included the Relay Source Port sub-option. Per RFC8357, replies to such a
+// relay agent are sent to the UDP source port of its message rather than to
+// the DHCP server port.
+func relaySourcePort(p *Packet) bool {
+	info, ok := p.GetRelayAgentInfo()
+	if !ok {
+		return false
+	}
+
+	_, ok = info.GetOption(RelayAgentSourcePort)
+	return ok
+}
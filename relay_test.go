@@ -0,0 +1,65 @@
+package dhcp4
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRelayAgentInfo(t *testing.T) {
+	info, err := ParseRelayAgentInfo([]byte{1, 2, 'e', '0', 2, 3, 'f', 'o', 'o', 19, 0})
+	if assert.NoError(t, err) {
+		assertOption(t, info, RelayAgentCircuitID, []byte("e0"))
+		assertOption(t, info, RelayAgentRemoteID, []byte("foo"))
+		assertOption(t, info, RelayAgentSourcePort, []byte{})
+	}
+
+	_, err = ParseRelayAgentInfo([]byte{1, 2, 'e'})
+	assert.Equal(t, ErrShortPacket, err)
+}
+
+func TestOptionMapGetRelayAgentInfo(t *testing.T) {
+	om := make(OptionMap)
+
+	_, ok := om.GetRelayAgentInfo()
+	assert.False(t, ok)
+
+	om.SetOption(OptionRelayAgentInformation, []byte{1, 2, 'e'})
+	_, ok = om.GetRelayAgentInfo()
+	assert.False(t, ok)
+
+	om.SetOption(OptionRelayAgentInformation, []byte{1, 2, 'e', '0'})
+	info, ok := om.GetRelayAgentInfo()
+	if assert.True(t, ok) {
+		assertOption(t, info, RelayAgentCircuitID, []byte("e0"))
+	}
+}
+
+func TestReplyDestinationRelaySourcePort(t *testing.T) {
+	relayIP := net.IP{10, 0, 0, 254}
+	src := &net.UDPAddr{IP: relayIP, Port: 30067}
+
+	msg := NewPacket(BootRequest)
+	msg.SetGIAddr(relayIP)
+
+	rep := NewReply(&msg)
+	rep.SetMessageType(MessageTypeOffer)
+
+	// Server port without the sub-option
+	dst := ReplyDestination(&msg, &rep, src)
+	assert.Equal(t, net.UDPAddr{IP: relayIP, Port: 67}, dst.Addr)
+
+	msg.SetOption(OptionRelayAgentInformation, []byte{1, 2, 'e', '0'})
+	dst = ReplyDestination(&msg, &rep, src)
+	assert.Equal(t, net.UDPAddr{IP: relayIP, Port: 67}, dst.Addr)
+
+	// Source port with the sub-option
+	msg.SetOption(OptionRelayAgentInformation, []byte{1, 2, 'e', '0', 19, 0})
+	dst = ReplyDestination(&msg, &rep, src)
+	assert.Equal(t, net.UDPAddr{IP: relayIP, Port: 30067}, dst.Addr)
+
+	// Server port if the source port is unknown
+	dst = ReplyDestination(&msg, &rep, nil)
+	assert.Equal(t, net.UDPAddr{IP: relayIP, Port: 67}, dst.Addr)
+}
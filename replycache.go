@@ -0,0 +1,265 @@
+package dhcp4
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Default maximum number of transactions a ReplyCache keeps.
+const defaultMaxReplyCacheEntries = 4096
+
+// ReplyCache is a middleware that invokes its handler once per transaction.
+// Clients retransmit DHCPDISCOVER, DHCPREQUEST and DHCPINFORM messages with
+// the same transaction ID until they get a reply. Within Window, ReplyCache
+// answers such retransmissions by writing the replies that were written for
+// the first message again, byte for byte, without calling the handler.
+// Retransmissions that arrive while the handler is still handling the first
+// message are dropped.
+//
+// Transactions are identified by client hardware address, transaction ID and
+// message type. Only transactions the handler wrote a reply for are cached;
+// if the handler did not answer, it is called again for the next
+// retransmission.
+type ReplyCache struct {
+	// Window is how long replies are cached for.
+	Window time.Duration
+
+	// MaxEntries is the maximum number of cached transactions. If zero, a
+	// default of 4096 is used. The least recently used transactions are
+	// evicted first.
+	MaxEntries int
+
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[replyCacheKey]*list.Element
+	now     func() time.Time
+
+	replayed   atomic.Uint64
+	suppressed atomic.Uint64
+}
+
+type replyCacheKey struct {
+	chaddr string
+	xid    [4]byte
+	t      MessageType
+
+	// Replies differ in whether they echo the client identifier, see
+	// Server.DisableClientIDEcho
+	noClientIDEcho bool
+}
+
+type replyCacheEntry struct {
+	key     replyCacheKey
+	pending bool
+	expires time.Time
+	replies []*cachedReply
+}
+
+// NewReplyCache returns a reply cache that caches replies for window.
+func NewReplyCache(window time.Duration) *ReplyCache {
+	return &ReplyCache{Window: window}
+}
+
+// Replayed returns the number of retransmissions that were answered from the
+// cache.
+func (c *ReplyCache) Replayed() uint64 {
+	return c.replayed.Load()
+}
+
+// Suppressed returns the number of retransmissions that were dropped because
+// the handler was still handling the first message.
+func (c *ReplyCache) Suppressed() uint64 {
+	return c.suppressed.Load()
+}
+
+// Wrap returns a handler that calls h once per transaction. It can be used as
+// Middleware.
+func (c *ReplyCache) Wrap(h Handler) Handler {
+	return HandlerFunc(func(w ReplyWriter, p *Packet) {
+		var key replyCacheKey
+		switch t := p.GetMessageType(); t {
+		case MessageTypeDiscover, MessageTypeRequest, MessageTypeInform:
+			key = replyCacheKey{chaddr: string(p.GetCHAddr()), t: t, noClientIDEcho: p.noClientIDEcho}
+			copy(key.xid[:], p.GetXID())
+		default:
+			h.ServeDHCP(w, p)
+			return
+		}
+
+		replies, pending := c.lookup(key)
+		if pending {
+			c.suppressed.Add(1)
+			return
+		}
+		if replies != nil {
+			c.replayed.Add(1)
+			for _, r := range replies {
+				w.WriteReply(r.replay(p))
+			}
+			return
+		}
+
+		cw := &cachingWriter{ReplyWriter: w}
+		defer func() {
+			c.store(key, cw.written())
+		}()
+
+		h.ServeDHCP(cw, p)
+	})
+}
+
+// lookup returns the cached replies of a transaction, or whether the handler
+// is still handling it. If neither, the transaction is marked as pending and
+// the caller must call store.
+func (c *ReplyCache) lookup(key replyCacheKey) ([]*cachedReply, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.lru = list.New()
+		c.entries = make(map[replyCacheKey]*list.Element)
+	}
+
+	if el, ok := c.entries[key]; ok {
+		e := el.Value.(*replyCacheEntry)
+		if e.pending {
+			return nil, true
+		}
+		if c.clock().Before(e.expires) {
+			c.lru.MoveToFront(el)
+			return e.replies, false
+		}
+		c.lru.Remove(el)
+		delete(c.entries, key)
+	}
+
+	e := &replyCacheEntry{key: key, pending: true}
+	c.entries[key] = c.lru.PushFront(e)
+	c.evict()
+	return nil, false
+}
+
+// store stores the replies of a transaction that was marked as pending, or
+// forgets about the transaction if there are none.
+func (c *ReplyCache) store(key replyCacheKey, replies []*cachedReply) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		// Evicted while pending
+		if len(replies) == 0 {
+			return
+		}
+		el = c.lru.PushFront(&replyCacheEntry{key: key})
+		c.entries[key] = el
+		c.evict()
+	}
+
+	if len(replies) == 0 {
+		c.lru.Remove(el)
+		delete(c.entries, key)
+		return
+	}
+
+	e := el.Value.(*replyCacheEntry)
+	e.pending = false
+	e.expires = c.clock().Add(c.Window)
+	e.replies = replies
+}
+
+// evict removes the least recently used entries while there are too many.
+func (c *ReplyCache) evict() {
+	max := c.MaxEntries
+	if max <= 0 {
+		max = defaultMaxReplyCacheEntries
+	}
+
+	for c.lru.Len() > max {
+		el := c.lru.Back()
+		c.lru.Remove(el)
+		delete(c.entries, el.Value.(*replyCacheEntry).key)
+	}
+}
+
+func (c *ReplyCache) clock() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
+
+// cachingWriter records the replies that are written successfully.
+type cachingWriter struct {
+	ReplyWriter
+
+	mu      sync.Mutex
+	replies []*cachedReply
+}
+
+func (w *cachingWriter) WriteReply(r Reply) error {
+	// Let the underlying writer report invalid replies
+	if err := r.Validate(); err != nil {
+		return w.ReplyWriter.WriteReply(r)
+	}
+
+	b, err := r.ToBytes()
+	if err != nil {
+		return w.ReplyWriter.WriteReply(r)
+	}
+
+	rep, err := PacketFromBytes(b)
+	if err != nil {
+		return w.ReplyWriter.WriteReply(r)
+	}
+
+	cr := &cachedReply{Packet: rep, msg: r.Message(), bytes: b}
+	if err := w.ReplyWriter.WriteReply(cr); err != nil {
+		return err
+	}
+
+	// Don't keep the request, which the Server reuses once the handler
+	// returns; replay sets the retransmission instead.
+	w.mu.Lock()
+	w.replies = append(w.replies, &cachedReply{Packet: rep, bytes: b})
+	w.mu.Unlock()
+	return nil
+}
+
+func (w *cachingWriter) written() []*cachedReply {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.replies
+}
+
+// cachedReply is a Reply that was already validated and serialized. Setting
+// fields or options on it does not change the serialized reply.
+type cachedReply struct {
+	Packet
+
+	msg   *Packet
+	bytes []byte
+}
+
+// replay returns a copy of the reply that answers the retransmission msg.
+func (r *cachedReply) replay(msg *Packet) *cachedReply {
+	return &cachedReply{Packet: r.Packet, msg: msg, bytes: r.bytes}
+}
+
+func (r *cachedReply) Validate() error {
+	return nil
+}
+
+func (r *cachedReply) ToBytes() ([]byte, error) {
+	return r.bytes, nil
+}
+
+func (r *cachedReply) Message() *Packet {
+	return r.msg
+}
+
+func (r *cachedReply) Reply() *Packet {
+	return &r.Packet
+}
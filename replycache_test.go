@@ -0,0 +1,181 @@
+package dhcp4
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingReplyWriter records the serialized replies written to it.
+type recordingReplyWriter struct {
+	info  RequestInfo
+	bytes [][]byte
+}
+
+func (w *recordingReplyWriter) WriteReply(r Reply) error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+	b, err := r.ToBytes()
+	if err != nil {
+		return err
+	}
+	w.bytes = append(w.bytes, b)
+	return nil
+}
+
+func (w *recordingReplyWriter) Info() *RequestInfo {
+	return &w.info
+}
+
+func newTestReplyCachePacket(t MessageType, xid byte) *Packet {
+	p := NewPacket(BootRequest)
+	p.SetMessageType(t)
+	p.HType()[0] = 1
+	p.HLen()[0] = 6
+	copy(p.CHAddr(), []byte{0, 0, 0, 0, 0, 1})
+	p.XID()[3] = xid
+	return &p
+}
+
+func TestReplyCache(t *testing.T) {
+	now := time.Unix(0, 0)
+
+	c := NewReplyCache(time.Second)
+	c.now = func() time.Time { return now }
+
+	// Every call answers with a different message
+	var calls int
+	h := c.Wrap(HandlerFunc(func(w ReplyWriter, p *Packet) {
+		calls++
+		nak := CreateNak(p)
+		nak.SetOption(OptionDHCPServerID, []byte{10, 0, 0, 1})
+		nak.SetString(OptionDHCPMessage, string(rune('a'+calls)))
+		w.WriteReply(&nak)
+	}))
+
+	w := &recordingReplyWriter{}
+	h.ServeDHCP(w, newTestReplyCachePacket(MessageTypeRequest, 1))
+	h.ServeDHCP(w, newTestReplyCachePacket(MessageTypeRequest, 1))
+	assert.Equal(t, 1, calls)
+	if assert.Len(t, w.bytes, 2) {
+		assert.Equal(t, w.bytes[0], w.bytes[1])
+	}
+
+	// Different transaction
+	h.ServeDHCP(w, newTestReplyCachePacket(MessageTypeRequest, 2))
+	assert.Equal(t, 2, calls)
+
+	// Different message type
+	h.ServeDHCP(w, newTestReplyCachePacket(MessageTypeDiscover, 1))
+	assert.Equal(t, 3, calls)
+
+	// Expired
+	now = now.Add(time.Second)
+	h.ServeDHCP(w, newTestReplyCachePacket(MessageTypeRequest, 1))
+	assert.Equal(t, 4, calls)
+	if assert.Len(t, w.bytes, 5) {
+		assert.NotEqual(t, w.bytes[0], w.bytes[4])
+	}
+
+	assert.Equal(t, uint64(1), c.Replayed())
+}
+
+func TestReplyCacheSuppressesPending(t *testing.T) {
+	c := NewReplyCache(time.Second)
+
+	var calls int
+	var h Handler
+	h = c.Wrap(HandlerFunc(func(w ReplyWriter, p *Packet) {
+		calls++
+
+		// A retransmission arrives while handling the first message
+		h.ServeDHCP(w, p)
+	}))
+
+	h.ServeDHCP(&recordingReplyWriter{}, newTestReplyCachePacket(MessageTypeDiscover, 1))
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, uint64(1), c.Suppressed())
+
+	// Nothing was written, so the handler is called again
+	h.ServeDHCP(&recordingReplyWriter{}, newTestReplyCachePacket(MessageTypeDiscover, 1))
+	assert.Equal(t, 2, calls)
+}
+
+func TestReplyCachePassesThroughOtherMessages(t *testing.T) {
+	c := NewReplyCache(time.Second)
+
+	var calls int
+	h := c.Wrap(HandlerFunc(func(w ReplyWriter, p *Packet) { calls++ }))
+
+	h.ServeDHCP(&recordingReplyWriter{}, newTestReplyCachePacket(MessageTypeRelease, 1))
+	h.ServeDHCP(&recordingReplyWriter{}, newTestReplyCachePacket(MessageTypeRelease, 1))
+	assert.Equal(t, 2, calls)
+}
+
+func TestReplyCacheReplayDestination(t *testing.T) {
+	c := NewReplyCache(time.Second)
+	h := c.Wrap(HandlerFunc(func(w ReplyWriter, p *Packet) {
+		nak := CreateNak(p)
+		nak.SetOption(OptionDHCPServerID, []byte{10, 0, 0, 1})
+		w.WriteReply(&nak)
+	}))
+
+	var replies []Reply
+	w := &testReplyCaptureWriter{replies: &replies}
+
+	msg := newTestReplyCachePacket(MessageTypeRequest, 1)
+	retransmission := newTestReplyCachePacket(MessageTypeRequest, 1)
+	h.ServeDHCP(w, msg)
+	h.ServeDHCP(w, retransmission)
+
+	if assert.Len(t, replies, 2) {
+		// The replayed reply answers the retransmission
+		assert.Equal(t, retransmission, replies[1].Message())
+		assert.Equal(t, MessageTypeNak, replies[1].Reply().GetMessageType())
+		assert.Equal(t, net.HardwareAddr{0, 0, 0, 0, 0, 1}, replies[1].Reply().GetCHAddr())
+	}
+}
+
+type testReplyCaptureWriter struct {
+	replies *[]Reply
+}
+
+func (w *testReplyCaptureWriter) WriteReply(r Reply) error {
+	*w.replies = append(*w.replies, r)
+	return nil
+}
+
+func (w *testReplyCaptureWriter) Info() *RequestInfo {
+	return &RequestInfo{}
+}
+
+func TestReplyCacheClientIDEcho(t *testing.T) {
+	c := NewReplyCache(time.Second)
+	h := c.Wrap(HandlerFunc(func(w ReplyWriter, p *Packet) {
+		nak := CreateNak(p)
+		nak.SetOption(OptionDHCPServerID, []byte{10, 0, 0, 1})
+		w.WriteReply(&nak)
+	}))
+
+	for _, noEcho := range []bool{false, true, false} {
+		p := newTestReplyCachePacket(MessageTypeRequest, 1)
+		p.SetOption(OptionClientID, []byte("\x00foo"))
+		p.noClientIDEcho = noEcho
+
+		w := &recordingReplyWriter{}
+		h.ServeDHCP(w, p)
+		if assert.Len(t, w.bytes, 1) {
+			rep, err := PacketFromBytes(w.bytes[0])
+			if assert.NoError(t, err) {
+				_, ok := rep.GetOption(OptionClientID)
+				assert.Equal(t, !noEcho, ok)
+			}
+		}
+	}
+
+	// The last request was answered from the cache
+	assert.Equal(t, uint64(1), c.Replayed())
+}
@@ -0,0 +1,465 @@
+package dhcp4
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+var ErrUnexpectedMessageType = errors.New("dhcp4: unexpected message type")
+
+// ClientState is the state of a client sending a DHCPREQUEST, as described in
+// RFC2131, section 4.3.2.
+type ClientState int
+
+const (
+	ClientStateSelecting  = ClientState(1)
+	ClientStateInitReboot = ClientState(2)
+	ClientStateRenewing   = ClientState(3)
+	ClientStateRebinding  = ClientState(4)
+)
+
+var clientStateStrings = map[ClientState]string{
+	ClientStateSelecting:  "SELECTING",
+	ClientStateInitReboot: "INIT-REBOOT",
+	ClientStateRenewing:   "RENEWING",
+	ClientStateRebinding:  "REBINDING",
+}
+
+func (s ClientState) String() string {
+	if str, ok := clientStateStrings[s]; ok {
+		return str
+	}
+	return fmt.Sprintf("STATE(%d)", int(s))
+}
+
+// MessageValidationError is returned when a client message violates a rule of
+// RFC2131, table 5. It wraps the error of the rule that failed.
+type MessageValidationError struct {
+	Type MessageType
+
+	// State is the client state for DHCPREQUEST messages, and zero otherwise.
+	State ClientState
+
+	Err error
+}
+
+func (e *MessageValidationError) Error() string {
+	msg := e.Type.String()
+	if e.State != 0 {
+		msg = fmt.Sprintf("%s in %s state", msg, e.State)
+	}
+	return fmt.Sprintf("dhcp4: invalid %s: %s", msg, strings.TrimPrefix(e.Err.Error(), "dhcp4: "))
+}
+
+func (e *MessageValidationError) Unwrap() error {
+	return e.Err
+}
+
+func validateMessage(p *Packet, s ClientState, vs []Validation) error {
+	if err := Validate(*p, vs); err != nil {
+		return &MessageValidationError{Type: p.GetMessageType(), State: s, Err: err}
+	}
+	return nil
+}
+
+// clientMessage holds the accessors shared by all client to server messages.
+type clientMessage struct {
+	*Packet
+}
+
+func newClientMessage(p *Packet, t MessageType) (clientMessage, error) {
+	if p.GetMessageType() != t {
+		return clientMessage{}, ErrUnexpectedMessageType
+	}
+	return clientMessage{p}, nil
+}
+
+// HardwareAddr returns the client hardware address.
+func (m clientMessage) HardwareAddr() net.HardwareAddr {
+	return m.GetCHAddr()
+}
+
+// ClientID returns the identity of the client, from the client identifier
+// option or the client hardware address.
+func (m clientMessage) ClientID() ClientID {
+	return m.GetClientID()
+}
+
+// Relayed returns whether the message was forwarded by a relay agent.
+func (m clientMessage) Relayed() bool {
+	return !m.GetGIAddr().Equal(net.IPv4zero)
+}
+
+// From RFC2131, table 5:
+//   Field      DHCPDISCOVER  DHCPREQUEST       DHCPDECLINE,
+//              DHCPINFORM                      DHCPRELEASE
+//   -----      ------------  -----------       -----------
+//   'ciaddr'   0 (DISCOVER)  0 or client's     0 (DECLINE)
+//              client's      network address   client's network
+//              network       (BOUND/RENEW/     address (RELEASE)
+//              address       REBIND)
+//              (INFORM)
+//
+//   Option                     DHCPDISCOVER  DHCPREQUEST      DHCPDECLINE,
+//                              DHCPINFORM                     DHCPRELEASE
+//   ------                     ------------  -----------      -----------
+//   Requested IP address       MAY           MUST (in         MUST
+//                              (DISCOVER)    SELECTING or     (DHCPDECLINE),
+//                              MUST NOT      INIT-REBOOT)     MUST NOT
+//                              (INFORM)      MUST NOT (in     (DHCPRELEASE)
+//                                            BOUND or
+//                                            RENEWING)
+//   IP address lease time      MAY           MAY              MUST NOT
+//                              (DISCOVER)
+//                              MUST NOT
+//                              (INFORM)
+//   Use 'file'/'sname' fields  MAY           MAY              MAY
+//   DHCP message type          DHCPDISCOVER/ DHCPREQUEST      DHCPDECLINE/
+//                              DHCPINFORM                     DHCPRELEASE
+//   Client identifier          MAY           MAY              MAY
+//   Vendor class identifier    MAY           MAY              MUST NOT
+//   Server identifier          MUST NOT      MUST (after      MUST
+//                                            SELECTING)
+//                                            MUST NOT (after
+//                                            INIT-REBOOT,
+//                                            BOUND, RENEWING
+//                                            or REBINDING)
+//   Parameter request list     MAY           MAY              MUST NOT
+//   Maximum message size       MAY           MAY              MUST NOT
+//   Message                    SHOULD NOT    SHOULD NOT       SHOULD
+//   Site-specific              MAY           MAY              MUST NOT
+//   All others                 MAY           MAY              MUST NOT
+
+// Discover is a client broadcast to locate available servers.
+type Discover struct {
+	clientMessage
+}
+
+// NewDiscover returns a view of p, which must be a DHCPDISCOVER.
+func NewDiscover(p *Packet) (Discover, error) {
+	m, err := newClientMessage(p, MessageTypeDiscover)
+	return Discover{m}, err
+}
+
+var dhcpDiscoverValidation = []Validation{
+	ValidateFieldZero(FieldCIAddr),
+	ValidateMustNot(OptionDHCPServerID),
+}
+
+func (d Discover) Validate() error {
+	return validateMessage(d.Packet, 0, dhcpDiscoverValidation)
+}
+
+// RequestedIP returns the address the client suggests, if any.
+func (d Discover) RequestedIP() (net.IP, bool) {
+	return d.GetIP(OptionAddressRequest)
+}
+
+// LeaseTime returns the lease time the client suggests, if any.
+func (d Discover) LeaseTime() (time.Duration, bool) {
+	return d.GetDuration(OptionAddressTime)
+}
+
+// MaxMessageSize returns the maximum DHCP message size the client accepts,
+// if specified.
+func (d Discover) MaxMessageSize() (uint16, bool) {
+	return d.GetUint16(OptionDHCPMaxMsgSize)
+}
+
+// VendorClass returns the vendor class identifier of the client, if any.
+func (d Discover) VendorClass() (string, bool) {
+	return d.GetString(OptionClassID)
+}
+
+// Request is a client message to servers either (a) requesting offered
+// parameters from one server and implicitly declining offers from all others,
+// (b) confirming correctness of previously allocated address after, e.g.,
+// system reboot, or (c) extending the lease on a particular network address.
+type Request struct {
+	clientMessage
+
+	// Dst is the local address the request was received on, if known. It is
+	// used to tell a RENEWING client (unicast) from a REBINDING client
+	// (broadcast).
+	Dst net.IP
+}
+
+// NewRequest returns a view of p, which must be a DHCPREQUEST. The
+// destination address dst is the one the request was received on, as found
+// in RequestInfo.Dst; it may be nil if it is not known.
+func NewRequest(p *Packet, dst net.IP) (Request, error) {
+	m, err := newClientMessage(p, MessageTypeRequest)
+	return Request{clientMessage: m, Dst: dst}, err
+}
+
+// State returns the state of the client, per RFC2131 section 4.3.2.
+//
+// A client in SELECTING state includes the server identifier. A client in
+// INIT-REBOOT state does not include a server identifier and has no 'ciaddr'.
+// A client in RENEWING or REBINDING state has a 'ciaddr', and they are told
+// apart by how the request was sent: unicast to the server when RENEWING, and
+// broadcast when REBINDING. Dst is a broadcast address if it is the limited
+// broadcast address 255.255.255.255, or the subnet-directed broadcast address
+// of one of the networks of the host. Since relay agents only forward
+// broadcasts, a relayed request is always REBINDING. If Dst is not known, the
+// client is assumed to be RENEWING.
+func (r Request) State() ClientState {
+	if _, ok := r.GetOption(OptionDHCPServerID); ok {
+		return ClientStateSelecting
+	}
+
+	if r.GetCIAddr().Equal(net.IPv4zero) {
+		return ClientStateInitReboot
+	}
+
+	if r.Relayed() || r.Dst.Equal(net.IPv4bcast) || hostInterfaces.isBroadcast(r.Dst) {
+		return ClientStateRebinding
+	}
+
+	return ClientStateRenewing
+}
+
+var dhcpRequestSelectingValidation = []Validation{
+	ValidateFieldZero(FieldCIAddr),
+	ValidateMust(OptionAddressRequest),
+	ValidateMust(OptionDHCPServerID),
+}
+
+var dhcpRequestInitRebootValidation = []Validation{
+	ValidateMust(OptionAddressRequest),
+	ValidateMustNot(OptionDHCPServerID),
+}
+
+var dhcpRequestBoundValidation = []Validation{
+	ValidateMustNot(OptionAddressRequest),
+	ValidateMustNot(OptionDHCPServerID),
+}
+
+func (r Request) Validate() error {
+	switch s := r.State(); s {
+	case ClientStateSelecting:
+		return validateMessage(r.Packet, s, dhcpRequestSelectingValidation)
+	case ClientStateInitReboot:
+		return validateMessage(r.Packet, s, dhcpRequestInitRebootValidation)
+	default:
+		return validateMessage(r.Packet, s, dhcpRequestBoundValidation)
+	}
+}
+
+// RequestedIP returns the address in the requested IP address option, if
+// any. It is set by clients in SELECTING and INIT-REBOOT state.
+func (r Request) RequestedIP() (net.IP, bool) {
+	return r.GetIP(OptionAddressRequest)
+}
+
+// ServerID returns the server identifier, if any. It is set by clients in
+// SELECTING state, and identifies the server whose offer they accept.
+func (r Request) ServerID() (net.IP, bool) {
+	return r.GetIP(OptionDHCPServerID)
+}
+
+// Address returns the address the client wants to use: the requested IP
+// address in SELECTING and INIT-REBOOT state, and 'ciaddr' otherwise. It
+// returns nil if the address is missing.
+func (r Request) Address() net.IP {
+	switch r.State() {
+	case ClientStateSelecting, ClientStateInitReboot:
+		ip, _ := r.RequestedIP()
+		return ip
+	}
+	return r.GetCIAddr()
+}
+
+// LeaseTime returns the lease time the client asks for, if any.
+func (r Request) LeaseTime() (time.Duration, bool) {
+	return r.GetDuration(OptionAddressTime)
+}
+
+// Decline is a client to server message indicating that the network address
+// is already in use.
+type Decline struct {
+	clientMessage
+}
+
+// NewDecline returns a view of p, which must be a DHCPDECLINE.
+func NewDecline(p *Packet) (Decline, error) {
+	m, err := newClientMessage(p, MessageTypeDecline)
+	return Decline{m}, err
+}
+
+var dhcpDeclineAllowedOptions = []Option{
+	OptionAddressRequest,
+	OptionOverload,
+	OptionDHCPMsgType,
+	OptionClientID,
+	OptionDHCPServerID,
+	OptionDHCPMessage,
+	OptionRelayAgentInformation,
+}
+
+var dhcpDeclineValidation = []Validation{
+	ValidateFieldZero(FieldCIAddr),
+	ValidateMust(OptionAddressRequest),
+	ValidateMust(OptionDHCPServerID),
+	ValidateAllowedOptions(dhcpDeclineAllowedOptions),
+}
+
+func (d Decline) Validate() error {
+	return validateMessage(d.Packet, 0, dhcpDeclineValidation)
+}
+
+// Address returns the address that is declined, or nil if it is missing.
+func (d Decline) Address() net.IP {
+	ip, _ := d.GetIP(OptionAddressRequest)
+	return ip
+}
+
+// ServerID returns the server identifier, if any.
+func (d Decline) ServerID() (net.IP, bool) {
+	return d.GetIP(OptionDHCPServerID)
+}
+
+// Reason returns the message explaining why the address is declined, if any.
+func (d Decline) Reason() (string, bool) {
+	return d.GetString(OptionDHCPMessage)
+}
+
+// Release is a client to server message relinquishing a network address and
+// cancelling the remaining lease.
+type Release struct {
+	clientMessage
+}
+
+// NewRelease returns a view of p, which must be a DHCPRELEASE.
+func NewRelease(p *Packet) (Release, error) {
+	m, err := newClientMessage(p, MessageTypeRelease)
+	return Release{m}, err
+}
+
+var dhcpReleaseAllowedOptions = []Option{
+	OptionOverload,
+	OptionDHCPMsgType,
+	OptionClientID,
+	OptionDHCPServerID,
+	OptionDHCPMessage,
+	OptionRelayAgentInformation,
+}
+
+var dhcpReleaseValidation = []Validation{
+	ValidateFieldSet(FieldCIAddr),
+	ValidateMust(OptionDHCPServerID),
+	ValidateAllowedOptions(dhcpReleaseAllowedOptions),
+}
+
+func (d Release) Validate() error {
+	return validateMessage(d.Packet, 0, dhcpReleaseValidation)
+}
+
+// Address returns the address that is released.
+func (d Release) Address() net.IP {
+	return d.GetCIAddr()
+}
+
+// ServerID returns the server identifier, if any.
+func (d Release) ServerID() (net.IP, bool) {
+	return d.GetIP(OptionDHCPServerID)
+}
+
+// Reason returns the message explaining why the address is released, if any.
+func (d Release) Reason() (string, bool) {
+	return d.GetString(OptionDHCPMessage)
+}
+
+// Inform is a client to server message asking only for local configuration
+// parameters; the client already has an externally configured network
+// address.
+type Inform struct {
+	clientMessage
+}
+
+// NewInform returns a view of p, which must be a DHCPINFORM.
+func NewInform(p *Packet) (Inform, error) {
+	m, err := newClientMessage(p, MessageTypeInform)
+	return Inform{m}, err
+}
+
+var dhcpInformValidation = []Validation{
+	ValidateFieldSet(FieldCIAddr),
+	ValidateMustNot(OptionAddressRequest),
+	ValidateMustNot(OptionAddressTime),
+	ValidateMustNot(OptionDHCPServerID),
+}
+
+func (d Inform) Validate() error {
+	return validateMessage(d.Packet, 0, dhcpInformValidation)
+}
+
+// Address returns the externally configured address of the client.
+func (d Inform) Address() net.IP {
+	return d.GetCIAddr()
+}
+
+// MaxMessageSize returns the maximum DHCP message size the client accepts,
+// if specified.
+func (d Inform) MaxMessageSize() (uint16, bool) {
+	return d.GetUint16(OptionDHCPMaxMsgSize)
+}
+
+// VendorClass returns the vendor class identifier of the client, if any.
+func (d Inform) VendorClass() (string, bool) {
+	return d.GetString(OptionClassID)
+}
+
+// ValidateRequest validates a client message against the rules of RFC2131,
+// table 5, for its message type. It returns a *MessageValidationError
+// describing the first rule that is violated, if any. Messages with a type
+// that a client must not send are invalid as well. The destination address
+// dst is used to determine the state of a DHCPREQUEST client, see
+// Request.State; it may be nil if it is not known.
+func ValidateRequest(p *Packet, dst net.IP) error {
+	switch t := p.GetMessageType(); t {
+	case MessageTypeDiscover:
+		return Discover{clientMessage{p}}.Validate()
+	case MessageTypeRequest:
+		return Request{clientMessage: clientMessage{p}, Dst: dst}.Validate()
+	case MessageTypeDecline:
+		return Decline{clientMessage{p}}.Validate()
+	case MessageTypeRelease:
+		return Release{clientMessage{p}}.Validate()
+	case MessageTypeInform:
+		return Inform{clientMessage{p}}.Validate()
+	default:
+		return &MessageValidationError{Type: t, Err: ErrUnexpectedMessageType}
+	}
+}
+
+// RequestValidator is a Handler that validates client messages with
+// ValidateRequest before passing them on to another Handler, using the
+// destination address from the RequestInfo of the ReplyWriter. Use it to wrap
+// the handler passed to Serve.
+type RequestValidator struct {
+	Handler Handler
+
+	// Drop causes invalid messages to be discarded. If false, invalid
+	// messages are flagged through OnInvalid and passed on regardless.
+	Drop bool
+
+	// OnInvalid, if set, is called for every invalid message.
+	OnInvalid func(p *Packet, err error)
+}
+
+func (v *RequestValidator) ServeDHCP(w ReplyWriter, p *Packet) {
+	if err := ValidateRequest(p, w.Info().Dst); err != nil {
+		if v.OnInvalid != nil {
+			v.OnInvalid(p, err)
+		}
+		if v.Drop {
+			return
+		}
+	}
+
+	v.Handler.ServeDHCP(w, p)
+}
@@ -0,0 +1,355 @@
+package dhcp4
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func newTestMessage(t MessageType) *Packet {
+	p := NewPacket(BootRequest)
+	p.SetMessageType(t)
+	return &p
+}
+
+func TestNewViewsCheckMessageType(t *testing.T) {
+	p := newTestMessage(MessageTypeDiscover)
+
+	_, err := NewDiscover(p)
+	assert.NoError(t, err)
+
+	_, err = NewRequest(p, nil)
+	assert.Equal(t, ErrUnexpectedMessageType, err)
+	_, err = NewDecline(p)
+	assert.Equal(t, ErrUnexpectedMessageType, err)
+	_, err = NewRelease(p)
+	assert.Equal(t, ErrUnexpectedMessageType, err)
+	_, err = NewInform(p)
+	assert.Equal(t, ErrUnexpectedMessageType, err)
+}
+
+func TestRequestState(t *testing.T) {
+	someIP := net.IP{10, 0, 0, 10}
+
+	type stateTest struct {
+		serverID net.IP
+		ciaddr   net.IP
+		giaddr   net.IP
+		dst      net.IP
+		state    ClientState
+	}
+
+	testCases := []stateTest{
+		{net.IP{10, 0, 0, 1}, nil, nil, nil, ClientStateSelecting},
+		{nil, nil, nil, nil, ClientStateInitReboot},
+		{nil, someIP, nil, nil, ClientStateRenewing},
+		{nil, someIP, nil, net.IP{10, 0, 0, 1}, ClientStateRenewing},
+		{nil, someIP, nil, net.IPv4bcast, ClientStateRebinding},
+		{nil, someIP, net.IP{10, 0, 0, 254}, nil, ClientStateRebinding},
+	}
+
+	// A request sent to the broadcast address of a network of the host, such
+	// as 127.255.255.255, is broadcast as well
+	if b := hostBroadcastAddr(); b != nil {
+		testCases = append(testCases, stateTest{nil, someIP, nil, b, ClientStateRebinding})
+	}
+
+	for _, testCase := range testCases {
+		p := newTestMessage(MessageTypeRequest)
+		if testCase.serverID != nil {
+			p.SetIP(OptionDHCPServerID, testCase.serverID)
+		}
+		p.SetCIAddr(testCase.ciaddr)
+		p.SetGIAddr(testCase.giaddr)
+
+		r, err := NewRequest(p, testCase.dst)
+		if assert.NoError(t, err) {
+			assert.Equal(t, testCase.state, r.State(), "expected %s", testCase.state)
+		}
+	}
+}
+
+// hostBroadcastAddr returns the broadcast address of a network of the host,
+// or nil if it has none.
+func hostBroadcastAddr() net.IP {
+	for _, ifi := range hostInterfaces.snapshot().ifaces {
+		for _, n := range ifi.nets {
+			if b := broadcastAddr(n); b != nil {
+				return b
+			}
+		}
+	}
+	return nil
+}
+
+func TestRequestAddress(t *testing.T) {
+	p := newTestMessage(MessageTypeRequest)
+	p.SetIP(OptionAddressRequest, net.IP{10, 0, 0, 10})
+
+	r, _ := NewRequest(p, nil)
+	assert.True(t, net.IP{10, 0, 0, 10}.Equal(r.Address()))
+
+	p = newTestMessage(MessageTypeRequest)
+	p.SetCIAddr(net.IP{10, 0, 0, 11})
+
+	r, _ = NewRequest(p, nil)
+	assert.True(t, net.IP{10, 0, 0, 11}.Equal(r.Address()))
+}
+
+func TestDiscoverValidation(t *testing.T) {
+	testCase := replyValidationTestCase{
+		newReply: func() ValidatingReply {
+			d, _ := NewDiscover(newTestMessage(MessageTypeDiscover))
+			return d
+		},
+		mustNot: []Option{
+			OptionDHCPServerID,
+		},
+	}
+
+	testCase.Test(t)
+}
+
+func TestRequestSelectingValidation(t *testing.T) {
+	// Only test the requested IP address, as the server identifier defines
+	// the SELECTING state.
+	testCase := replyValidationTestCase{
+		newReply: func() ValidatingReply {
+			p := newTestMessage(MessageTypeRequest)
+			p.SetOption(OptionDHCPServerID, []byte("foo"))
+			r, _ := NewRequest(p, nil)
+			return r
+		},
+		must: []Option{
+			OptionAddressRequest,
+		},
+	}
+
+	testCase.Test(t)
+}
+
+func TestRequestInitRebootValidation(t *testing.T) {
+	testCase := replyValidationTestCase{
+		newReply: func() ValidatingReply {
+			r, _ := NewRequest(newTestMessage(MessageTypeRequest), nil)
+			return r
+		},
+		must: []Option{
+			OptionAddressRequest,
+		},
+	}
+
+	testCase.Test(t)
+}
+
+func TestRequestRenewingValidation(t *testing.T) {
+	testCase := replyValidationTestCase{
+		newReply: func() ValidatingReply {
+			p := newTestMessage(MessageTypeRequest)
+			p.SetCIAddr(net.IP{10, 0, 0, 10})
+			r, _ := NewRequest(p, nil)
+			return r
+		},
+		mustNot: []Option{
+			OptionAddressRequest,
+		},
+	}
+
+	testCase.Test(t)
+}
+
+func TestDeclineValidation(t *testing.T) {
+	testCase := replyValidationTestCase{
+		newReply: func() ValidatingReply {
+			d, _ := NewDecline(newTestMessage(MessageTypeDecline))
+			return d
+		},
+		must: []Option{
+			OptionAddressRequest,
+			OptionDHCPServerID,
+		},
+		mustNot: []Option{
+			OptionAddressTime,
+			OptionClassID,
+			OptionParameterList,
+			OptionDHCPMaxMsgSize,
+			OptionPXEUndefined128,
+		},
+	}
+
+	testCase.Test(t)
+}
+
+func TestReleaseValidation(t *testing.T) {
+	testCase := replyValidationTestCase{
+		newReply: func() ValidatingReply {
+			p := newTestMessage(MessageTypeRelease)
+			p.SetCIAddr(net.IP{10, 0, 0, 10})
+			d, _ := NewRelease(p)
+			return d
+		},
+		must: []Option{
+			OptionDHCPServerID,
+		},
+		mustNot: []Option{
+			OptionAddressRequest,
+			OptionAddressTime,
+			OptionClassID,
+			OptionParameterList,
+			OptionDHCPMaxMsgSize,
+			OptionPXEUndefined128,
+		},
+	}
+
+	testCase.Test(t)
+}
+
+func TestInformValidation(t *testing.T) {
+	testCase := replyValidationTestCase{
+		newReply: func() ValidatingReply {
+			p := newTestMessage(MessageTypeInform)
+			p.SetCIAddr(net.IP{10, 0, 0, 10})
+			d, _ := NewInform(p)
+			return d
+		},
+		mustNot: []Option{
+			OptionAddressRequest,
+			OptionAddressTime,
+			OptionDHCPServerID,
+		},
+	}
+
+	testCase.Test(t)
+}
+
+func TestValidateRequest(t *testing.T) {
+	var err error
+
+	// DECLINE without requested IP address
+	p := newTestMessage(MessageTypeDecline)
+	p.SetIP(OptionDHCPServerID, net.IP{10, 0, 0, 1})
+
+	err = ValidateRequest(p, nil)
+	assert.Equal(t, &MessageValidationError{
+		Type: MessageTypeDecline,
+		Err: ValidationErrors{
+			&ValidationError{Option: OptionAddressRequest, MustHave: true},
+		},
+	}, err)
+	assert.EqualError(t, err, "dhcp4: invalid DHCPDECLINE: packet MUST have field 50")
+
+	// RELEASE with requested IP address
+	p = newTestMessage(MessageTypeRelease)
+	p.SetCIAddr(net.IP{10, 0, 0, 10})
+	p.SetIP(OptionDHCPServerID, net.IP{10, 0, 0, 1})
+	p.SetIP(OptionAddressRequest, net.IP{10, 0, 0, 10})
+
+	err = ValidateRequest(p, nil)
+	assert.EqualError(t, err, "dhcp4: invalid DHCPRELEASE: packet MUST NOT have field 50")
+
+	// REQUEST in SELECTING state with ciaddr
+	p = newTestMessage(MessageTypeRequest)
+	p.SetCIAddr(net.IP{10, 0, 0, 10})
+	p.SetIP(OptionDHCPServerID, net.IP{10, 0, 0, 1})
+	p.SetIP(OptionAddressRequest, net.IP{10, 0, 0, 10})
+
+	err = ValidateRequest(p, nil)
+	assert.EqualError(t, err, "dhcp4: invalid DHCPREQUEST in SELECTING state: packet MUST have zero 'ciaddr'")
+
+	// Valid DISCOVER
+	p = newTestMessage(MessageTypeDiscover)
+	assert.NoError(t, ValidateRequest(p, nil))
+
+	// Server to client message
+	p = newTestMessage(MessageTypeOffer)
+	err = ValidateRequest(p, nil)
+	if assert.IsType(t, &MessageValidationError{}, err) {
+		assert.Equal(t, ErrUnexpectedMessageType, err.(*MessageValidationError).Err)
+	}
+}
+
+func TestRequestValidator(t *testing.T) {
+	invalid := newTestMessage(MessageTypeDiscover)
+	invalid.SetIP(OptionDHCPServerID, net.IP{10, 0, 0, 1})
+
+	for _, drop := range []bool{true, false} {
+		var flagged []error
+
+		h := &testHandler{}
+		h.On("ServeDHCP", mock.Anything, mock.Anything).Return()
+
+		v := RequestValidator{
+			Handler: h,
+			Drop:    drop,
+			OnInvalid: func(p *Packet, err error) {
+				flagged = append(flagged, err)
+			},
+		}
+
+		v.ServeDHCP(&testReplyWriter{}, newTestMessage(MessageTypeDiscover))
+		v.ServeDHCP(&testReplyWriter{}, invalid)
+
+		assert.Len(t, flagged, 1)
+		if drop {
+			h.AssertNumberOfCalls(t, "ServeDHCP", 1)
+		} else {
+			h.AssertNumberOfCalls(t, "ServeDHCP", 2)
+		}
+	}
+}
+
+// dstPacketConn is a chanPacketConn that reports destination addresses, which
+// are queued on dsts before every packet.
+type dstPacketConn struct {
+	*chanPacketConn
+	dsts chan net.IP
+}
+
+func (pc *dstPacketConn) ReadFromDst(b []byte) (int, net.Addr, int, net.IP, error) {
+	n, addr, ifindex, err := pc.ReadFrom(b)
+	if err != nil {
+		return n, addr, ifindex, nil, err
+	}
+	return n, addr, ifindex, <-pc.dsts, nil
+}
+
+func TestServerRequestState(t *testing.T) {
+	pc := &dstPacketConn{newChanPacketConn(), make(chan net.IP, 2)}
+
+	states := make(chan ClientState, 2)
+	s := Server{
+		Handler: &RequestValidator{
+			Drop: true,
+			Handler: HandlerFunc(func(w ReplyWriter, p *Packet) {
+				r, err := NewRequest(p, w.Info().Dst)
+				if assert.NoError(t, err) {
+					states <- r.State()
+				}
+			}),
+		},
+	}
+
+	errc := make(chan error)
+	go func() { errc <- s.Serve(context.Background(), pc) }()
+
+	p := newTestMessage(MessageTypeRequest)
+	p.SetCIAddr(net.IP{10, 0, 0, 10})
+	b, err := PacketToBytes(*p, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pc.dsts <- net.IPv4bcast
+	pc.in <- b
+	assert.Equal(t, ClientStateRebinding, <-states)
+
+	pc.dsts <- net.IP{10, 0, 0, 1}
+	pc.in <- b
+	assert.Equal(t, ClientStateRenewing, <-states)
+
+	assert.NoError(t, s.Close())
+	assert.Equal(t, ErrServerClosed, <-errc)
+}
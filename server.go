@@ -0,0 +1,557 @@
+package dhcp4
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DropPolicy defines which packet is dropped when a packet arrives while the
+// queue of a Server is full.
+type DropPolicy int
+
+const (
+	// DropNewest drops the packet that just arrived.
+	DropNewest = DropPolicy(0)
+
+	// DropOldest drops the packet that has been queued the longest, and
+	// queues the packet that just arrived. Clients retransmit requests that
+	// go unanswered, so the oldest packet is the most likely to be stale.
+	DropOldest = DropPolicy(1)
+)
+
+// Default number of packets that can be queued when Server.Workers is set.
+const defaultQueueSize = 256
+
+// ServerStats holds counters for the packets dispatched by a Server.
+type ServerStats struct {
+	// Queued is the number of packets that were queued for a worker.
+	Queued uint64
+
+	// Dropped is the number of packets that were dropped because the queue
+	// was full.
+	Dropped uint64
+
+	// QueueLen is the number of packets currently waiting for a worker.
+	QueueLen int
+}
+
+// ErrServerClosed is returned by the Server's Serve and ListenAndServe
+// methods after a call to Shutdown or Close.
+var ErrServerClosed = errors.New("dhcp4: Server closed")
+
+// Server reads packets off one or more PacketConns and calls its Handler for
+// every request. The zero value is not usable; at least Handler must be set.
+type Server struct {
+	// Handler is called for every request.
+	Handler Handler
+
+	// Addr optionally specifies the UDP address to listen on in
+	// ListenAndServe. If empty, ":67" is used.
+	Addr string
+
+	// ParseError, if set, is called for every packet that cannot be parsed.
+	// The packet buffer b is only valid for the duration of the call.
+	ParseError func(b []byte, addr net.Addr, ifindex int, err error)
+
+	// Workers is the number of goroutines calling Handler. If zero, Handler
+	// is called on the goroutine that reads packets, and every packet waits
+	// for the handler of the previous packet to return.
+	Workers int
+
+	// QueueSize is the number of packets that can wait for a worker when all
+	// workers are busy. If zero, a default of 256 is used. It is ignored if
+	// Workers is zero.
+	QueueSize int
+
+	// DropPolicy defines which packet is dropped when the queue is full.
+	DropPolicy DropPolicy
+
+	// Logger is used to log received, sent and dropped packets and parse
+	// errors at debug level, and other errors at higher levels. If nil,
+	// slog.Default() is used.
+	Logger *slog.Logger
+
+	// Metrics, if set, receives events for monitoring.
+	Metrics Metrics
+
+	// ServerIDs configures how the server identifier of requests is derived.
+	ServerIDs ServerIDs
+
+	// ReuseRequests makes the server reuse the packets handlers are called
+	// with, so that it doesn't allocate a packet and an option map for every
+	// request. Only enable it if the handler doesn't use the packet, or
+	// replies created from it, after ServeDHCP returns; handlers that answer
+	// from other goroutines must copy the packet first.
+	ReuseRequests bool
+
+	// DisableClientIDEcho stops CreateOffer, CreateAck and CreateNak from
+	// copying the client identifier of the server's requests into replies,
+	// for strict RFC2131 behavior where DHCPOFFER and DHCPACK MUST NOT carry
+	// it. By default it is echoed, as required by RFC6842.
+	DisableClientIDEcho bool
+
+	// BatchSize is the maximum number of packets read or written with a
+	// single system call, for PacketConns that implement BatchConn. If zero
+	// or one, packets are read and written one at a time. Replies are only
+	// written in batches if Workers is set, since they are otherwise written
+	// one at a time by the goroutine reading packets.
+	BatchSize int
+
+	mu       sync.Mutex
+	conns    map[PacketConn]struct{}
+	shutdown bool
+
+	// In-flight and queued calls to Handler
+	handlers sync.WaitGroup
+
+	startOnce sync.Once
+	stopOnce  sync.Once
+	queue     chan serverJob
+	quit      chan struct{}
+
+	queued  atomic.Uint64
+	dropped atomic.Uint64
+}
+
+type serverJob struct {
+	rw ReplyWriter
+	p  *Packet
+}
+
+// readDeadliner is implemented by PacketConns that support read deadlines,
+// such as the ones returned by NewPacketConn. It allows the serve loop to be
+// stopped without closing the connection, so that in-flight handlers can
+// still write their replies.
+type readDeadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
+// Serve reads packets off pc and calls s.Handler for every request, until
+// reading from pc fails, ctx is done, or the server is shut down.
+//
+// Serve always returns a non-nil error. After Shutdown or Close, the returned
+// error is ErrServerClosed. If ctx is done, the returned error is ctx.Err().
+//
+// Serve can be called multiple times, for different PacketConns, to serve
+// all of them concurrently.
+func (s *Server) Serve(ctx context.Context, pc PacketConn) error {
+	if !s.trackConn(pc, true) {
+		return ErrServerClosed
+	}
+	defer s.trackConn(pc, false)
+
+	s.startOnce.Do(s.startWorkers)
+
+	// Stop reading when the context is done
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			stopReading(pc)
+		case <-stop:
+		}
+	}()
+
+	if bc, ok := pc.(BatchConn); ok && s.BatchSize > 1 {
+		return s.serveBatch(ctx, pc, bc)
+	}
+
+	buf := readBuffers.Get().(*[]byte)
+	defer readBuffers.Put(buf)
+
+	for {
+		n, addr, ifindex, dst, err := readFromDst(pc, *buf)
+		if err != nil {
+			return s.readError(ctx, pc, err)
+		}
+
+		s.serveDHCP(pc, pc, (*buf)[:n], addr, ifindex, dst)
+	}
+}
+
+// readError returns the error Serve returns when reading from pc fails.
+func (s *Server) readError(ctx context.Context, pc PacketConn, err error) error {
+	if s.shuttingDown() {
+		return ErrServerClosed
+	}
+	if ctx.Err() != nil {
+		// Make the connection usable again
+		if d, ok := pc.(readDeadliner); ok {
+			d.SetReadDeadline(time.Time{})
+		}
+		return ctx.Err()
+	}
+	return err
+}
+
+// ListenAndServe listens on s.Addr and calls Serve. The connection is closed
+// when Serve returns.
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	c, err := Listen(s.Addr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	return s.Serve(ctx, c)
+}
+
+// Shutdown gracefully shuts down the server. It stops reading packets, waits
+// for in-flight handlers to return, and then closes all connections. If ctx is
+// done before the handlers have returned, Shutdown closes the connections
+// anyway and returns ctx.Err().
+func (s *Server) Shutdown(ctx context.Context) error {
+	conns := s.stop()
+	for _, pc := range conns {
+		stopReading(pc)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.handlers.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	s.stopWorkers()
+	for _, pc := range conns {
+		pc.Close()
+	}
+
+	return err
+}
+
+// Close immediately closes all connections, without waiting for in-flight
+// handlers to return. Replies they write afterwards fail. Requests that are
+// still queued for a worker are dropped.
+func (s *Server) Close() error {
+	var err error
+
+	conns := s.stop()
+	s.stopWorkers()
+	for _, pc := range conns {
+		if cerr := pc.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// stopReading unblocks a pending ReadFrom on pc. It uses a read deadline if
+// possible, and closes the connection otherwise.
+func stopReading(pc PacketConn) {
+	if d, ok := pc.(readDeadliner); ok {
+		if err := d.SetReadDeadline(time.Unix(1, 0)); err == nil {
+			return
+		}
+	}
+	pc.Close()
+}
+
+// trackConn adds or removes pc from the set of connections being served. It
+// returns false if the server is shutting down.
+func (s *Server) trackConn(pc PacketConn, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !add {
+		delete(s.conns, pc)
+		return true
+	}
+
+	if s.shutdown {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[PacketConn]struct{})
+	}
+	s.conns[pc] = struct{}{}
+	return true
+}
+
+// stop marks the server as shutting down and returns the connections being
+// served.
+func (s *Server) stop() []PacketConn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.shutdown = true
+
+	conns := make([]PacketConn, 0, len(s.conns))
+	for pc := range s.conns {
+		conns = append(conns, pc)
+	}
+	return conns
+}
+
+func (s *Server) shuttingDown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.shutdown
+}
+
+// startHandler registers an in-flight handler. It returns false if the server
+// is shutting down, in which case the handler must not be called.
+func (s *Server) startHandler() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.shutdown {
+		return false
+	}
+	s.handlers.Add(1)
+	return true
+}
+
+func (s *Server) logger() *slog.Logger {
+	if s.Logger != nil {
+		return s.Logger
+	}
+	return slog.Default()
+}
+
+func (s *Server) metrics() Metrics {
+	if s.Metrics != nil {
+		return s.Metrics
+	}
+	return nopMetrics{}
+}
+
+// serveDHCP handles a packet read from pc. Replies are written to pw.
+func (s *Server) serveDHCP(pc PacketConn, pw PacketWriter, b []byte, addr net.Addr, ifindex int, dst net.IP) {
+	received := time.Now()
+	logger := s.logger()
+	metrics := s.metrics()
+
+	// Index the packet first, so that packets that are dropped aren't copied
+	var x OptionIndex
+	if err := x.Parse(b); err != nil {
+		// Logged at debug level, as these come in floods; they are counted by
+		// Metrics.ParseError
+		logger.Debug("failed to parse packet", "event", "error", "src", addr, "err", err)
+		metrics.ParseError(parseErrorCause(b, err))
+		if s.ParseError != nil {
+			s.ParseError(b, addr, ifindex, err)
+		}
+		return
+	}
+
+	// Filter everything but requests
+	if op := OpCode(b[0]); op != BootRequest {
+		logger.Debug("ignoring packet", "event", "drop", "op", op, "mac", RawPacket(b).GetCHAddr().String())
+		metrics.PacketDropped("not_request")
+		return
+	}
+
+	p := s.newRequest(&x)
+	p.noClientIDEcho = s.DisableClientIDEcho
+
+	a, _ := addr.(*net.UDPAddr)
+	if a != nil && logger.Enabled(context.Background(), slog.LevelDebug) {
+		logger.LogAttrs(context.Background(), slog.LevelDebug, "received request",
+			fieldsToAttrs(toFields("recv", ifindex, a.IP, p, nil))...)
+	}
+
+	rw := &replyWriter{
+		pw: pw,
+		info: RequestInfo{
+			Src:      a,
+			IfIndex:  ifindex,
+			Dst:      dst,
+			Received: received,
+		},
+		logger:  logger,
+		metrics: metrics,
+	}
+
+	if l, ok := pc.(InterfaceLookup); ok {
+		if name, addr, ok := l.LookupInterface(ifindex); ok {
+			rw.info.IfName = name
+			rw.info.IfAddr = addr
+		}
+	}
+	rw.info.ServerID = s.serverID(p, &rw.info)
+
+	// Only these messages are answered by the server (RFC2131, section 4.3)
+	switch p.GetMessageType() {
+	case MessageTypeDiscover, MessageTypeRequest, MessageTypeInform:
+	default:
+		rw.noReply = true
+	}
+
+	if !s.startHandler() {
+		metrics.PacketDropped("shutdown")
+		s.releaseRequest(p)
+		return
+	}
+
+	if s.queue == nil {
+		defer s.handlers.Done()
+		s.serve(rw, p)
+		return
+	}
+
+	s.enqueue(serverJob{rw: rw, p: p})
+}
+
+// Stats returns the counters of the server.
+func (s *Server) Stats() ServerStats {
+	return ServerStats{
+		Queued:   s.queued.Load(),
+		Dropped:  s.dropped.Load(),
+		QueueLen: len(s.queue),
+	}
+}
+
+// newRequest returns a copy of the packet indexed by x, to call the handler
+// with. It comes from a pool if ReuseRequests is set.
+func (s *Server) newRequest(x *OptionIndex) *Packet {
+	if s.ReuseRequests {
+		return getRequest(x)
+	}
+	p := x.Packet()
+	return &p
+}
+
+// releaseRequest releases a packet returned by newRequest once the handler
+// is done with it.
+func (s *Server) releaseRequest(p *Packet) {
+	if s.ReuseRequests {
+		putRequest(p)
+	}
+}
+
+// serve calls the handler and records how long it took. The request is
+// released once the handler returns.
+func (s *Server) serve(rw ReplyWriter, p *Packet) {
+	s.metrics().PacketReceived(p.GetMessageType())
+
+	start := time.Now()
+	s.Handler.ServeDHCP(rw, p)
+	s.metrics().HandlerDuration(p.GetMessageType(), time.Since(start))
+	s.releaseRequest(p)
+}
+
+func (s *Server) startWorkers() {
+	if s.Workers <= 0 {
+		return
+	}
+
+	size := s.QueueSize
+	if size <= 0 {
+		size = defaultQueueSize
+	}
+
+	s.queue = make(chan serverJob, size)
+	s.quit = make(chan struct{})
+	for i := 0; i < s.Workers; i++ {
+		go s.worker()
+	}
+}
+
+// stopWorkers stops the workers. Jobs that are still queued are dropped.
+func (s *Server) stopWorkers() {
+	s.stopOnce.Do(func() {
+		// Prevent workers from being started after this
+		s.startOnce.Do(func() {})
+
+		if s.quit != nil {
+			close(s.quit)
+			s.drainQueue()
+		}
+	})
+}
+
+// drainQueue drops the jobs in the queue once the workers are stopped.
+func (s *Server) drainQueue() {
+	for {
+		select {
+		case j := <-s.queue:
+			s.drop(j, "shutdown")
+		default:
+			return
+		}
+	}
+}
+
+func (s *Server) worker() {
+	for {
+		select {
+		case j := <-s.queue:
+			s.serve(j.rw, j.p)
+			s.handlers.Done()
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+// enqueue queues a job for the workers, applying the drop policy if the queue
+// is full. It is only called from the goroutines reading packets.
+func (s *Server) enqueue(j serverJob) {
+	if s.push(j) {
+		return
+	}
+
+	if s.DropPolicy == DropOldest {
+		select {
+		case old := <-s.queue:
+			s.dropped.Add(1)
+			s.drop(old, "queue_full")
+		default:
+		}
+
+		if s.push(j) {
+			return
+		}
+	}
+
+	s.dropped.Add(1)
+	s.drop(j, "queue_full")
+}
+
+// push queues j if the queue isn't full. If the workers were stopped in the
+// meantime, the queue is drained so that j isn't abandoned.
+func (s *Server) push(j serverJob) bool {
+	select {
+	case s.queue <- j:
+		s.queued.Add(1)
+	default:
+		return false
+	}
+
+	select {
+	case <-s.quit:
+		s.drainQueue()
+	default:
+	}
+	return true
+}
+
+// drop drops a job that was not handled, for the specified reason, as
+// reported to Metrics.PacketDropped.
+func (s *Server) drop(j serverJob, reason string) {
+	s.handlers.Done()
+	s.metrics().PacketDropped(reason)
+
+	// Logged at debug level, since requests are dropped when the server is
+	// overloaded; they are counted by Stats and Metrics.PacketDropped
+	s.logger().Debug("dropping request",
+		"event", "drop",
+		"reason", reason,
+		"mac", j.p.GetCHAddr().String(),
+		"xid", formatHex(j.p.XID()))
+	s.releaseRequest(j.p)
+}
@@ -0,0 +1,502 @@
+package dhcp4
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// chanPacketConn is a PacketConn that reads packets from a channel and
+// supports read deadlines, like the PacketConn returned by NewPacketConn.
+type chanPacketConn struct {
+	in     chan []byte
+	writes chan []byte
+
+	mu       sync.Mutex
+	expired  chan struct{}
+	closed   chan struct{}
+	isClosed bool
+}
+
+func newChanPacketConn() *chanPacketConn {
+	return &chanPacketConn{
+		in:      make(chan []byte),
+		writes:  make(chan []byte, 16),
+		expired: make(chan struct{}),
+		closed:  make(chan struct{}),
+	}
+}
+
+func (pc *chanPacketConn) ReadFrom(b []byte) (int, net.Addr, int, error) {
+	pc.mu.Lock()
+	expired := pc.expired
+	pc.mu.Unlock()
+
+	select {
+	case buf := <-pc.in:
+		return copy(b, buf), &net.UDPAddr{IP: net.IPv4zero, Port: 68}, 1, nil
+	case <-expired:
+		return 0, nil, -1, os.ErrDeadlineExceeded
+	case <-pc.closed:
+		return 0, nil, -1, net.ErrClosed
+	}
+}
+
+func (pc *chanPacketConn) WriteTo(b []byte, addr net.Addr, ifindex int) (int, error) {
+	select {
+	case <-pc.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	// Like a real connection, don't retain b
+	pc.writes <- append([]byte(nil), b...)
+	return len(b), nil
+}
+
+func (pc *chanPacketConn) SetReadDeadline(t time.Time) error {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	if t.IsZero() {
+		pc.expired = make(chan struct{})
+	} else if t.Before(time.Now()) {
+		close(pc.expired)
+	}
+	return nil
+}
+
+func (pc *chanPacketConn) Close() error {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	if pc.isClosed {
+		return net.ErrClosed
+	}
+	pc.isClosed = true
+	close(pc.closed)
+	return nil
+}
+
+func (pc *chanPacketConn) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4zero, Port: 67}
+}
+
+func newTestDiscover(t *testing.T) []byte {
+	p := NewPacket(BootRequest)
+	p.SetMessageType(MessageTypeDiscover)
+	b, err := PacketToBytes(p, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestServerShutdownWaitsForHandlers(t *testing.T) {
+	pc := newChanPacketConn()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	returned := make(chan struct{})
+
+	s := Server{
+		Handler: HandlerFunc(func(w ReplyWriter, p *Packet) {
+			close(started)
+			<-release
+			close(returned)
+		}),
+	}
+
+	errc := make(chan error)
+	go func() { errc <- s.Serve(context.Background(), pc) }()
+
+	pc.in <- newTestDiscover(t)
+	<-started
+
+	shutdownc := make(chan error)
+	go func() { shutdownc <- s.Shutdown(context.Background()) }()
+
+	// Shutdown must wait for the handler
+	select {
+	case <-shutdownc:
+		t.Fatal("Shutdown returned before the handler did")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(release)
+	assert.NoError(t, <-shutdownc)
+	assert.Equal(t, ErrServerClosed, <-errc)
+
+	select {
+	case <-returned:
+	default:
+		t.Fatal("handler did not return")
+	}
+
+	// The connection is closed after the handlers returned
+	assert.Equal(t, net.ErrClosed, pc.Close())
+
+	// Serving after shutdown fails
+	assert.Equal(t, ErrServerClosed, s.Serve(context.Background(), newChanPacketConn()))
+}
+
+func TestServerShutdownTimeout(t *testing.T) {
+	pc := newChanPacketConn()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+
+	s := Server{
+		Handler: HandlerFunc(func(w ReplyWriter, p *Packet) {
+			close(started)
+			<-release
+		}),
+	}
+
+	go s.Serve(context.Background(), pc)
+
+	pc.in <- newTestDiscover(t)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	assert.Equal(t, context.DeadlineExceeded, s.Shutdown(ctx))
+	assert.Equal(t, net.ErrClosed, pc.Close())
+}
+
+func TestServerServeContextCanceled(t *testing.T) {
+	pc := newChanPacketConn()
+	s := Server{Handler: HandlerFunc(func(w ReplyWriter, p *Packet) {})}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	errc := make(chan error)
+	go func() { errc <- s.Serve(ctx, pc) }()
+
+	cancel()
+	assert.Equal(t, context.Canceled, <-errc)
+
+	// The connection remains usable
+	assert.NoError(t, pc.Close())
+}
+
+func TestServerClose(t *testing.T) {
+	pc := newChanPacketConn()
+	s := Server{Handler: HandlerFunc(func(w ReplyWriter, p *Packet) {})}
+
+	errc := make(chan error)
+	go func() { errc <- s.Serve(context.Background(), pc) }()
+
+	// Make sure Serve is reading
+	pc.in <- newTestDiscover(t)
+
+	assert.NoError(t, s.Close())
+	assert.Equal(t, ErrServerClosed, <-errc)
+}
+
+func TestServerParseError(t *testing.T) {
+	pc := newChanPacketConn()
+
+	var parseErrors []error
+	s := Server{
+		Handler: HandlerFunc(func(w ReplyWriter, p *Packet) {}),
+		ParseError: func(b []byte, addr net.Addr, ifindex int, err error) {
+			assert.Equal(t, []byte("garbage"), b)
+			assert.Equal(t, 1, ifindex)
+			parseErrors = append(parseErrors, err)
+		},
+	}
+
+	errc := make(chan error)
+	go func() { errc <- s.Serve(context.Background(), pc) }()
+
+	pc.in <- []byte("garbage")
+	pc.Close()
+
+	assert.True(t, errors.Is(<-errc, net.ErrClosed))
+	assert.Equal(t, []error{ErrShortPacket}, parseErrors)
+}
+
+func newTestDiscoverXID(t *testing.T, xid byte) []byte {
+	b := newTestDiscover(t)
+	b[7] = xid
+	return b
+}
+
+func TestServerWorkers(t *testing.T) {
+	pc := newChanPacketConn()
+
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+
+	s := Server{
+		Workers: 2,
+		Handler: HandlerFunc(func(w ReplyWriter, p *Packet) {
+			started <- struct{}{}
+			<-release
+		}),
+	}
+
+	go s.Serve(context.Background(), pc)
+
+	pc.in <- newTestDiscover(t)
+	pc.in <- newTestDiscover(t)
+
+	// Both handlers run concurrently
+	<-started
+	<-started
+	close(release)
+
+	assert.NoError(t, s.Shutdown(context.Background()))
+	assert.Equal(t, ServerStats{Queued: 2}, s.Stats())
+}
+
+func TestServerCloseDropsQueuedRequests(t *testing.T) {
+	pc := newChanPacketConn()
+
+	started := make(chan struct{}, 3)
+	release := make(chan struct{})
+
+	s := Server{
+		Workers:   1,
+		QueueSize: 4,
+		Handler: HandlerFunc(func(w ReplyWriter, p *Packet) {
+			started <- struct{}{}
+			<-release
+		}),
+	}
+
+	errc := make(chan error)
+	go func() { errc <- s.Serve(context.Background(), pc) }()
+
+	// The first packet occupies the worker, the others wait in the queue
+	pc.in <- newTestDiscoverXID(t, 1)
+	<-started
+	pc.in <- newTestDiscoverXID(t, 2)
+	pc.in <- newTestDiscoverXID(t, 3)
+	pc.in <- []byte("garbage")
+
+	assert.NoError(t, s.Close())
+	assert.Equal(t, ErrServerClosed, <-errc)
+	close(release)
+
+	// Shutdown doesn't wait for the queued requests that were dropped
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, s.Shutdown(ctx))
+	assert.Len(t, started, 0)
+	assert.Equal(t, 0, s.Stats().QueueLen)
+}
+
+func testServerDropPolicy(t *testing.T, policy DropPolicy) []byte {
+	pc := newChanPacketConn()
+
+	started := make(chan struct{}, 3)
+	release := make(chan struct{})
+
+	var mu sync.Mutex
+	var handled []byte
+
+	s := Server{
+		Workers:    1,
+		QueueSize:  1,
+		DropPolicy: policy,
+		Handler: HandlerFunc(func(w ReplyWriter, p *Packet) {
+			started <- struct{}{}
+			<-release
+
+			mu.Lock()
+			handled = append(handled, p.XID()[3])
+			mu.Unlock()
+		}),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	errc := make(chan error)
+	go func() { errc <- s.Serve(ctx, pc) }()
+
+	// The first packet occupies the worker, the second fills the queue
+	pc.in <- newTestDiscoverXID(t, 1)
+	<-started
+	pc.in <- newTestDiscoverXID(t, 2)
+	pc.in <- newTestDiscoverXID(t, 3)
+
+	// Serve returns after dispatching the last packet
+	cancel()
+	assert.Equal(t, context.Canceled, <-errc)
+	assert.Equal(t, ServerStats{Queued: 2 + uint64(policy), Dropped: 1, QueueLen: 1}, s.Stats())
+
+	close(release)
+	assert.NoError(t, s.Shutdown(context.Background()))
+	assert.Equal(t, 0, s.Stats().QueueLen)
+
+	return handled
+}
+
+func TestServerDropNewest(t *testing.T) {
+	assert.Equal(t, []byte{1, 2}, testServerDropPolicy(t, DropNewest))
+}
+
+func TestServerDropOldest(t *testing.T) {
+	assert.Equal(t, []byte{1, 3}, testServerDropPolicy(t, DropOldest))
+}
+
+func TestServerRequestInfo(t *testing.T) {
+	pc := newChanPacketConn()
+
+	type result struct {
+		info RequestInfo
+		err  error
+	}
+
+	results := make(chan result)
+	s := Server{
+		Handler: HandlerFunc(func(w ReplyWriter, p *Packet) {
+			nak := CreateNak(p)
+			nak.SetOption(OptionDHCPServerID, []byte{10, 0, 0, 1})
+			results <- result{info: *w.Info(), err: w.WriteReply(&nak)}
+		}),
+	}
+
+	go s.Serve(context.Background(), pc)
+	defer s.Close()
+
+	testCases := []struct {
+		t   MessageType
+		err error
+	}{
+		{MessageTypeDecline, ErrNoReply},
+		{MessageTypeRelease, ErrNoReply},
+		{MessageType(200), ErrNoReply},
+		{MessageTypeRequest, nil},
+	}
+
+	for _, testCase := range testCases {
+		p := NewPacket(BootRequest)
+		p.SetMessageType(testCase.t)
+		b, err := PacketToBytes(p, nil)
+		if !assert.NoError(t, err) {
+			continue
+		}
+
+		before := time.Now()
+		pc.in <- b
+		r := <-results
+
+		assert.Equal(t, testCase.err, r.err)
+		assert.Equal(t, &net.UDPAddr{IP: net.IPv4zero, Port: 68}, r.info.Src)
+		assert.Equal(t, 1, r.info.IfIndex)
+		assert.False(t, r.info.Received.Before(before))
+	}
+}
+
+func TestServerDisableClientIDEcho(t *testing.T) {
+	for _, disable := range []bool{false, true} {
+		pc := newChanPacketConn()
+
+		s := Server{
+			DisableClientIDEcho: disable,
+			Handler: HandlerFunc(func(w ReplyWriter, p *Packet) {
+				rep := CreateOffer(p)
+				rep.SetYIAddr(net.IP{10, 0, 0, 10})
+				rep.SetDuration(OptionAddressTime, time.Hour)
+				rep.SetIP(OptionDHCPServerID, net.IP{10, 0, 0, 1})
+				assert.NoError(t, w.WriteReply(&rep))
+			}),
+		}
+
+		errc := make(chan error)
+		go func() { errc <- s.Serve(context.Background(), pc) }()
+
+		msg := NewPacket(BootRequest)
+		msg.SetMessageType(MessageTypeDiscover)
+		msg.SetOption(OptionClientID, []byte("\x00foo"))
+		b, err := PacketToBytes(msg, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pc.in <- b
+
+		rep, err := PacketFromBytes(<-pc.writes)
+		if assert.NoError(t, err) {
+			_, ok := rep.GetOption(OptionClientID)
+			assert.Equal(t, !disable, ok)
+		}
+
+		assert.NoError(t, s.Close())
+		assert.Equal(t, ErrServerClosed, <-errc)
+	}
+}
+
+// BenchmarkServerServeDHCP measures the allocations made by the serve loop for
+// every request, with a handler that doesn't reply.
+func BenchmarkServerServeDHCP(b *testing.B) {
+	for _, reuse := range []bool{false, true} {
+		b.Run(fmt.Sprintf("reuse=%t", reuse), func(b *testing.B) {
+			pc := newChanPacketConn()
+			buf := newRelayedDiscover(b, 1)
+			addr := &net.UDPAddr{IP: net.IP{10, 0, 0, 254}, Port: ServerPort}
+
+			s := Server{
+				Handler:       HandlerFunc(func(w ReplyWriter, p *Packet) {}),
+				Logger:        slog.New(slog.NewTextHandler(io.Discard, nil)),
+				ReuseRequests: reuse,
+			}
+
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				s.serveDHCP(pc, pc, buf, addr, 1, nil)
+			}
+		})
+	}
+}
+
+func TestServerDisableClientIDEchoReplyCache(t *testing.T) {
+	pc := newChanPacketConn()
+
+	s := Server{
+		DisableClientIDEcho: true,
+		Handler: NewReplyCache(time.Second).Wrap(HandlerFunc(func(w ReplyWriter, p *Packet) {
+			rep := CreateOffer(p)
+			rep.SetYIAddr(net.IP{10, 0, 0, 10})
+			rep.SetDuration(OptionAddressTime, time.Hour)
+			rep.SetIP(OptionDHCPServerID, net.IP{10, 0, 0, 1})
+			assert.NoError(t, w.WriteReply(&rep))
+		})),
+	}
+
+	errc := make(chan error)
+	go func() { errc <- s.Serve(context.Background(), pc) }()
+
+	msg := NewPacket(BootRequest)
+	msg.SetMessageType(MessageTypeDiscover)
+	msg.SetOption(OptionClientID, []byte("\x00foo"))
+	b, err := PacketToBytes(msg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The retransmission is answered from the cache
+	for i := 0; i < 2; i++ {
+		pc.in <- b
+		rep, err := PacketFromBytes(<-pc.writes)
+		if assert.NoError(t, err) {
+			_, ok := rep.GetOption(OptionClientID)
+			assert.False(t, ok)
+		}
+	}
+
+	assert.NoError(t, s.Close())
+	assert.Equal(t, ErrServerClosed, <-errc)
+}
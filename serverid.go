@@ -0,0 +1,92 @@
+package dhcp4
+
+import "net"
+
+// ServerIDs configures how the server identifier (option 54) of requests is
+// derived, which is set in RequestInfo.ServerID. It is, in order of
+// precedence:
+//
+//   - the override for the relay agent, for relayed requests;
+//   - the override for the interface the request arrived on;
+//   - the address of the server on the subnet of the relay agent, for
+//     requests relayed from a directly attached network;
+//   - the address the request was sent to, if it is an address of the
+//     server, since the client already uses it as the server identifier;
+//   - the primary IPv4 address of the interface the request arrived on.
+//
+// The zero value derives server identifiers without overrides.
+type ServerIDs struct {
+	// Interfaces maps interface names to the server identifier of the
+	// requests that arrive on them.
+	Interfaces map[string]net.IP
+
+	// Relays maps relay agent addresses ('giaddr') to the server identifier
+	// of the requests they relay.
+	Relays map[string]net.IP
+}
+
+// serverID derives the server identifier of request p, received as described
+// by info.
+func (s *Server) serverID(p *Packet, info *RequestInfo) net.IP {
+	giaddr := p.GetGIAddr()
+	relayed := !giaddr.Equal(net.IPv4zero)
+
+	if relayed && len(s.ServerIDs.Relays) > 0 {
+		if ip, ok := s.ServerIDs.Relays[giaddr.String()]; ok {
+			return ip
+		}
+	}
+
+	if info.IfName != "" {
+		if ip, ok := s.ServerIDs.Interfaces[info.IfName]; ok {
+			return ip
+		}
+	}
+
+	if relayed {
+		if ip := hostInterfaces.subnetAddr(giaddr); ip != nil {
+			return ip
+		}
+	}
+
+	if ip := info.Dst.To4(); ip != nil && hostInterfaces.hasAddr(ip) {
+		return ip
+	}
+
+	return info.IfAddr
+}
+
+// SetServerID sets the server identifier of r to the one derived for the
+// request, unless r already has one. It returns false if r has no server
+// identifier afterwards, because none could be derived.
+func SetServerID(w ReplyWriter, r Reply) bool {
+	if _, ok := r.Reply().GetOption(OptionDHCPServerID); ok {
+		return true
+	}
+
+	ip := w.Info().ServerID.To4()
+	if ip == nil || ip.Equal(net.IPv4zero) {
+		return false
+	}
+
+	r.SetIP(OptionDHCPServerID, ip)
+	return true
+}
+
+// ServerIDHandler returns a handler that calls SetServerID for every reply h
+// writes, so that h doesn't need to set the server identifier. In a Chain, it
+// should come after middleware that inspects replies, such as a ReplyCache.
+func ServerIDHandler(h Handler) Handler {
+	return HandlerFunc(func(w ReplyWriter, p *Packet) {
+		h.ServeDHCP(&serverIDWriter{w}, p)
+	})
+}
+
+type serverIDWriter struct {
+	ReplyWriter
+}
+
+func (w *serverIDWriter) WriteReply(r Reply) error {
+	SetServerID(w.ReplyWriter, r)
+	return w.ReplyWriter.WriteReply(r)
+}
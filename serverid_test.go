@@ -0,0 +1,84 @@
+package dhcp4
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServerID(t *testing.T) {
+	s := Server{
+		ServerIDs: ServerIDs{
+			Interfaces: map[string]net.IP{"eth1": {10, 1, 0, 1}},
+			Relays:     map[string]net.IP{"203.0.113.1": {10, 2, 0, 1}},
+		},
+	}
+
+	local := net.IP{127, 0, 0, 1}
+	ifAddr := net.IP{10, 0, 0, 1}
+
+	testCases := []struct {
+		name     string
+		giaddr   net.IP
+		info     RequestInfo
+		expected net.IP
+	}{
+		{"interface address", nil, RequestInfo{IfName: "eth0", IfAddr: ifAddr}, ifAddr},
+		{"interface override", nil, RequestInfo{IfName: "eth1", IfAddr: ifAddr}, net.IP{10, 1, 0, 1}},
+		{"relay override", net.IP{203, 0, 113, 1}, RequestInfo{IfName: "eth1", IfAddr: ifAddr}, net.IP{10, 2, 0, 1}},
+		{"relay subnet", net.IP{127, 0, 0, 5}, RequestInfo{IfName: "eth0", IfAddr: ifAddr}, local},
+		{"relay elsewhere", net.IP{198, 51, 100, 1}, RequestInfo{IfName: "eth0", IfAddr: ifAddr}, ifAddr},
+		{"unicast", nil, RequestInfo{Dst: local, IfAddr: ifAddr}, local},
+		{"broadcast", nil, RequestInfo{Dst: net.IPv4bcast, IfAddr: ifAddr}, ifAddr},
+		{"unknown", nil, RequestInfo{}, nil},
+	}
+
+	for _, tc := range testCases {
+		p := NewPacket(BootRequest)
+		if tc.giaddr != nil {
+			p.SetGIAddr(tc.giaddr)
+		}
+
+		actual := s.serverID(&p, &tc.info)
+		assert.True(t, tc.expected.Equal(actual), "%s: expected %s, got %s", tc.name, tc.expected, actual)
+	}
+}
+
+func TestSetServerID(t *testing.T) {
+	msg := NewPacket(BootRequest)
+	w := &testReplyWriter{}
+
+	// Nothing to set
+	offer := CreateOffer(&msg)
+	assert.False(t, SetServerID(w, &offer))
+	_, ok := offer.GetOption(OptionDHCPServerID)
+	assert.False(t, ok)
+
+	// Set from the request
+	w.info.ServerID = net.IP{10, 0, 0, 1}
+	assert.True(t, SetServerID(w, &offer))
+	assertOption(t, offer.OptionMap, OptionDHCPServerID, []byte{10, 0, 0, 1})
+
+	// Not overwritten
+	w.info.ServerID = net.IP{10, 0, 0, 2}
+	assert.True(t, SetServerID(w, &offer))
+	assertOption(t, offer.OptionMap, OptionDHCPServerID, []byte{10, 0, 0, 1})
+}
+
+func TestServerIDHandler(t *testing.T) {
+	msg := NewPacket(BootRequest)
+	msg.SetMessageType(MessageTypeDiscover)
+
+	var offer Offer
+	h := ServerIDHandler(HandlerFunc(func(w ReplyWriter, p *Packet) {
+		offer = CreateOffer(p)
+		w.WriteReply(&offer)
+	}))
+
+	w := &testReplyWriter{info: RequestInfo{ServerID: net.IP{10, 0, 0, 1}}}
+	h.ServeDHCP(w, &msg)
+
+	assert.True(t, w.wrote)
+	assertOption(t, offer.OptionMap, OptionDHCPServerID, []byte{10, 0, 0, 1})
+}
@@ -1,18 +1,48 @@
 package dhcp4
 
-import "fmt"
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+)
 
 type Validation interface {
 	Validate(p Packet) error
 }
 
+// Validate runs all validations against the packet. It returns nil if they
+// all pass, and ValidationErrors holding every violation otherwise.
 func Validate(p Packet, vs []Validation) error {
+	var errs ValidationErrors
 	for _, v := range vs {
 		if err := v.Validate(p); err != nil {
-			return err
+			errs = append(errs, err)
 		}
 	}
-	return nil
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// ValidationErrors holds all the violations found when validating a packet.
+type ValidationErrors []error
+
+func (e ValidationErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = strings.TrimPrefix(err.Error(), "dhcp4: ")
+	}
+	return fmt.Sprintf("dhcp4: %d violations: %s", len(e), strings.Join(msgs, "; "))
+}
+
+func (e ValidationErrors) Unwrap() []error {
+	return e
 }
 
 type ValidationError struct {
@@ -47,6 +77,114 @@ func ValidateMust(o Option) Validation {
 	return validateMust{o, true}
 }
 
+// EchoValidationError is returned when a reply does not carry the exact value
+// of an option that it must copy from the request.
+type EchoValidationError struct {
+	Option
+}
+
+func (e *EchoValidationError) Error() string {
+	return fmt.Sprintf("dhcp4: packet MUST echo field %d from the request", e.Option)
+}
+
+type validateEcho struct {
+	o   Option
+	msg *Packet
+}
+
+func (v validateEcho) Validate(p Packet) error {
+	expected, must := v.msg.GetOption(v.o)
+	actual, have := p.GetOption(v.o)
+	if must != have {
+		return &ValidationError{Option: v.o, MustHave: must}
+	}
+	if must && !bytes.Equal(expected, actual) {
+		return &EchoValidationError{Option: v.o}
+	}
+	return nil
+}
+
+// ValidateEcho returns a validation that checks that the packet has option o
+// with the same value as msg if msg has it, and doesn't have it otherwise.
+func ValidateEcho(o Option, msg *Packet) Validation {
+	return validateEcho{o, msg}
+}
+
+// Field identifies one of the address fields in the fixed-format portion of a
+// packet.
+type Field int
+
+const (
+	FieldCIAddr = Field(1)
+	FieldYIAddr = Field(2)
+	FieldSIAddr = Field(3)
+	FieldGIAddr = Field(4)
+)
+
+var fieldStrings = map[Field]string{
+	FieldCIAddr: "ciaddr",
+	FieldYIAddr: "yiaddr",
+	FieldSIAddr: "siaddr",
+	FieldGIAddr: "giaddr",
+}
+
+func (f Field) String() string {
+	if s, ok := fieldStrings[f]; ok {
+		return s
+	}
+	return fmt.Sprintf("field(%d)", int(f))
+}
+
+func (f Field) get(p Packet) net.IP {
+	switch f {
+	case FieldCIAddr:
+		return p.GetCIAddr()
+	case FieldYIAddr:
+		return p.GetYIAddr()
+	case FieldSIAddr:
+		return p.GetSIAddr()
+	case FieldGIAddr:
+		return p.GetGIAddr()
+	}
+	return nil
+}
+
+type FieldValidationError struct {
+	Field
+	MustBeSet bool
+}
+
+func (e *FieldValidationError) Error() string {
+	if e.MustBeSet {
+		return fmt.Sprintf("dhcp4: packet MUST have non-zero '%s'", e.Field)
+	}
+	return fmt.Sprintf("dhcp4: packet MUST have zero '%s'", e.Field)
+}
+
+type validateField struct {
+	f   Field
+	set bool
+}
+
+func (v validateField) Validate(p Packet) error {
+	if ip := v.f.get(p); v.set == ip.Equal(net.IPv4zero) {
+		return &FieldValidationError{Field: v.f, MustBeSet: v.set}
+	}
+	return nil
+}
+
+// ValidateFieldZero returns a validation that checks that an address field is
+// zero.
+func ValidateFieldZero(f Field) Validation {
+	return validateField{f, false}
+}
+
+// ValidateFieldSet returns a validation that checks that an address field is
+// not zero.
+func ValidateFieldSet(f Field) Validation {
+	return validateField{f, true}
+}
+
 type validateAllowedOptions struct {
 	allowed map[Option]bool
 }
@@ -69,3 +207,98 @@ func ValidateAllowedOptions(os []Option) Validation {
 
 	return validateAllowedOptions{allowed}
 }
+
+// ConsistencyError is returned when the values of options contradict each
+// other.
+type ConsistencyError struct {
+	Options []Option
+	Reason  string
+}
+
+func (e *ConsistencyError) Error() string {
+	return fmt.Sprintf("dhcp4: fields %v are inconsistent: %s", e.Options, e.Reason)
+}
+
+type validateLeaseTimes struct{}
+
+func (v validateLeaseTimes) Validate(p Packet) error {
+	// Times are compared as encoded, so that infinity (0xffffffff) works.
+	lease, hasLease := p.GetUint32(OptionAddressTime)
+	t1, hasT1 := p.GetUint32(OptionRenewalTime)
+	t2, hasT2 := p.GetUint32(OptionRebindingTime)
+
+	switch {
+	case hasT1 && hasT2 && t1 > t2:
+		return &ConsistencyError{
+			Options: []Option{OptionRenewalTime, OptionRebindingTime},
+			Reason:  fmt.Sprintf("renewal time %ds exceeds rebinding time %ds", t1, t2),
+		}
+	case hasT1 && hasLease && t1 > lease:
+		return &ConsistencyError{
+			Options: []Option{OptionRenewalTime, OptionAddressTime},
+			Reason:  fmt.Sprintf("renewal time %ds exceeds lease time %ds", t1, lease),
+		}
+	case hasT2 && hasLease && t2 > lease:
+		return &ConsistencyError{
+			Options: []Option{OptionRebindingTime, OptionAddressTime},
+			Reason:  fmt.Sprintf("rebinding time %ds exceeds lease time %ds", t2, lease),
+		}
+	}
+	return nil
+}
+
+// ValidateLeaseTimes returns a validation that checks that the renewal (T1)
+// time does not exceed the rebinding (T2) time, and that neither exceeds the
+// lease time.
+func ValidateLeaseTimes() Validation {
+	return validateLeaseTimes{}
+}
+
+type validateSubnet struct{}
+
+func (v validateSubnet) Validate(p Packet) error {
+	m, ok := p.GetOption(OptionSubnetMask)
+	if !ok {
+		return nil
+	}
+
+	mask := net.IPMask(m)
+	if ones, bits := mask.Size(); len(m) != 4 || (ones == 0 && bits == 0) {
+		return &ConsistencyError{
+			Options: []Option{OptionSubnetMask},
+			Reason:  fmt.Sprintf("%s is not a valid subnet mask", net.IP(m)),
+		}
+	}
+
+	routers, ok := p.GetOption(OptionRouter)
+	if !ok {
+		return nil
+	}
+
+	// The address the client is configured with
+	addr := p.GetYIAddr()
+	if addr.Equal(net.IPv4zero) {
+		addr = p.GetCIAddr()
+	}
+	if addr.Equal(net.IPv4zero) {
+		return nil
+	}
+
+	subnet := net.IPNet{IP: addr.Mask(mask), Mask: mask}
+	for i := 0; i+4 <= len(routers); i += 4 {
+		router := net.IP(routers[i : i+4])
+		if !subnet.Contains(router) {
+			return &ConsistencyError{
+				Options: []Option{OptionSubnetMask, OptionRouter},
+				Reason:  fmt.Sprintf("router %s is outside of subnet %s", router, subnet.String()),
+			}
+		}
+	}
+	return nil
+}
+
+// ValidateSubnet returns a validation that checks that the subnet mask is
+// valid and that the routers are in the subnet of the client address.
+func ValidateSubnet() Validation {
+	return validateSubnet{}
+}
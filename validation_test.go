@@ -1,6 +1,7 @@
 package dhcp4
 
 import (
+	"net"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -57,3 +58,140 @@ func TestValidateAllowedOptions(t *testing.T) {
 	err = Validate(p, []Validation{v})
 	assert.Error(t, err)
 }
+
+func TestValidateEcho(t *testing.T) {
+	var err error
+
+	msg := NewPacket(BootRequest)
+	p := NewPacket(BootReply)
+	v := ValidateEcho(OptionClientID, &msg)
+
+	// Neither has the option
+	err = Validate(p, []Validation{v})
+	assert.NoError(t, err)
+
+	// Only the reply has the option
+	p.SetOption(OptionClientID, []byte("foo"))
+	err = Validate(p, []Validation{v})
+	assert.Equal(t, ValidationErrors{&ValidationError{Option: OptionClientID, MustHave: false}}, err)
+
+	// Both have the option, with different values
+	msg.SetOption(OptionClientID, []byte("bar"))
+	err = Validate(p, []Validation{v})
+	assert.Equal(t, ValidationErrors{&EchoValidationError{Option: OptionClientID}}, err)
+
+	// Both have the option, with the same value
+	p.SetOption(OptionClientID, []byte("bar"))
+	err = Validate(p, []Validation{v})
+	assert.NoError(t, err)
+
+	// Only the request has the option
+	delete(p.OptionMap, OptionClientID)
+	err = Validate(p, []Validation{v})
+	assert.Equal(t, ValidationErrors{&ValidationError{Option: OptionClientID, MustHave: true}}, err)
+}
+
+func TestValidateField(t *testing.T) {
+	var err error
+
+	p := NewPacket(BootReply)
+	zero := ValidateFieldZero(FieldYIAddr)
+	set := ValidateFieldSet(FieldYIAddr)
+
+	err = Validate(p, []Validation{zero})
+	assert.NoError(t, err)
+	err = Validate(p, []Validation{set})
+	assert.Equal(t, ValidationErrors{&FieldValidationError{Field: FieldYIAddr, MustBeSet: true}}, err)
+
+	p.SetYIAddr(net.IP{10, 0, 0, 10})
+	err = Validate(p, []Validation{zero})
+	assert.Equal(t, ValidationErrors{&FieldValidationError{Field: FieldYIAddr, MustBeSet: false}}, err)
+	err = Validate(p, []Validation{set})
+	assert.NoError(t, err)
+}
+
+func TestValidateReturnsAllViolations(t *testing.T) {
+	p := NewPacket(BootReply)
+	p.SetOption(OptionRouter, []byte("foo"))
+
+	err := Validate(p, []Validation{
+		ValidateMust(OptionSubnetMask),
+		ValidateMustNot(OptionRouter),
+		ValidateFieldSet(FieldYIAddr),
+	})
+
+	assert.Equal(t, ValidationErrors{
+		&ValidationError{Option: OptionSubnetMask, MustHave: true},
+		&ValidationError{Option: OptionRouter, MustHave: false},
+		&FieldValidationError{Field: FieldYIAddr, MustBeSet: true},
+	}, err)
+	assert.EqualError(t, err, "dhcp4: 3 violations: "+
+		"packet MUST have field 1; "+
+		"packet MUST NOT have field 3; "+
+		"packet MUST have non-zero 'yiaddr'")
+}
+
+func TestValidateLeaseTimes(t *testing.T) {
+	testCases := []struct {
+		lease, t1, t2 uint32
+		valid         bool
+	}{
+		{3600, 1800, 3150, true},
+		{3600, 3600, 3600, true},
+		{0xffffffff, 1800, 3150, true},
+		{3600, 3150, 1800, false},
+		{3600, 7200, 0, false},
+		{3600, 0, 7200, false},
+	}
+
+	for _, testCase := range testCases {
+		p := NewPacket(BootReply)
+		p.SetUint32(OptionAddressTime, testCase.lease)
+		if testCase.t1 > 0 {
+			p.SetUint32(OptionRenewalTime, testCase.t1)
+		}
+		if testCase.t2 > 0 {
+			p.SetUint32(OptionRebindingTime, testCase.t2)
+		}
+
+		err := Validate(p, []Validation{ValidateLeaseTimes()})
+		if testCase.valid {
+			assert.NoError(t, err)
+		} else if assert.Error(t, err) {
+			assert.IsType(t, &ConsistencyError{}, err.(ValidationErrors)[0])
+		}
+	}
+}
+
+func TestValidateSubnet(t *testing.T) {
+	testCases := []struct {
+		yiaddr net.IP
+		mask   net.IP
+		router []byte
+		valid  bool
+	}{
+		{net.IP{10, 0, 0, 10}, net.IP{255, 255, 255, 0}, []byte{10, 0, 0, 1}, true},
+		{net.IP{10, 0, 0, 10}, net.IP{255, 255, 255, 0}, []byte{10, 0, 0, 1, 10, 0, 0, 2}, true},
+		{net.IP{10, 0, 0, 10}, net.IP{255, 255, 255, 0}, nil, true},
+		{net.IP{10, 0, 0, 10}, net.IP{255, 255, 255, 0}, []byte{10, 0, 1, 1}, false},
+		{net.IP{10, 0, 0, 10}, net.IP{255, 255, 255, 0}, []byte{10, 0, 0, 1, 10, 0, 1, 1}, false},
+		{net.IP{10, 0, 0, 10}, net.IP{255, 0, 255, 0}, []byte{10, 0, 0, 1}, false},
+		{net.IP{0, 0, 0, 0}, net.IP{255, 255, 255, 0}, []byte{10, 0, 1, 1}, true},
+	}
+
+	for _, testCase := range testCases {
+		p := NewPacket(BootReply)
+		p.SetYIAddr(testCase.yiaddr)
+		p.SetIP(OptionSubnetMask, testCase.mask)
+		if testCase.router != nil {
+			p.SetOption(OptionRouter, testCase.router)
+		}
+
+		err := Validate(p, []Validation{ValidateSubnet()})
+		if testCase.valid {
+			assert.NoError(t, err)
+		} else if assert.Error(t, err) {
+			assert.IsType(t, &ConsistencyError{}, err.(ValidationErrors)[0])
+		}
+	}
+}
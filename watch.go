@@ -0,0 +1,202 @@
+package dhcp4
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+)
+
+// errWatchUnsupported is returned by WatchInterfaces on platforms without
+// netlink.
+var errWatchUnsupported = errors.New("dhcp4: watching interfaces is not supported")
+
+// InterfaceWatcher watches the interfaces of the host and their addresses.
+// While one is open, the server's view of the interfaces, which sets
+// RequestInfo.IfName and RequestInfo.IfAddr and names interfaces in logs, is
+// updated as soon as they change instead of every few seconds.
+type InterfaceWatcher struct {
+	conn    *netlinkConn
+	changes chan struct{}
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// WatchInterfaces starts watching the interfaces of the host. It is only
+// supported on Linux, where it subscribes to netlink notifications of links
+// and IPv4 addresses (RTM_NEWLINK, RTM_DELLINK, RTM_NEWADDR and RTM_DELADDR).
+func WatchInterfaces() (*InterfaceWatcher, error) {
+	conn, err := listenNetlink()
+	if err != nil {
+		return nil, err
+	}
+
+	w := &InterfaceWatcher{
+		conn:    conn,
+		changes: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+
+	hostInterfaces.watch(1)
+	go w.run()
+	return w, nil
+}
+
+func (w *InterfaceWatcher) run() {
+	defer close(w.done)
+	defer close(w.changes)
+	defer hostInterfaces.watch(-1)
+
+	for {
+		changed, err := w.conn.readChange()
+		if err != nil {
+			return
+		}
+		if !changed {
+			continue
+		}
+
+		hostInterfaces.invalidate()
+
+		// Coalesce changes the receiver hasn't seen yet
+		select {
+		case w.changes <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Changes returns a channel that receives a value after interfaces or their
+// addresses change. Changes that happen before the previous one is received
+// are coalesced. The channel is closed when the watcher stops.
+func (w *InterfaceWatcher) Changes() <-chan struct{} {
+	return w.changes
+}
+
+// Close stops watching interfaces.
+func (w *InterfaceWatcher) Close() error {
+	var err error
+	w.closeOnce.Do(func() {
+		err = w.conn.Close()
+		<-w.done
+	})
+	return err
+}
+
+// interfaceServer is a per-interface listener of WatchAndServeInterfaces.
+type interfaceServer struct {
+	index  int
+	name   string
+	conn   PacketConn
+	cancel context.CancelFunc
+}
+
+type interfaceServerResult struct {
+	is  *interfaceServer
+	err error
+}
+
+// WatchAndServeInterfaces is ListenAndServeInterfaces for hosts whose
+// interfaces change, such as VLAN subinterfaces and bridges coming and going.
+// It watches the interfaces with WatchInterfaces, starts serving the
+// interfaces matched by f as they come up, and stops serving the ones that go
+// down or are removed. Every interface gets its own socket, bound with
+// ListenInterface, so it is only supported on Linux.
+//
+// An interface whose socket fails is not served until interfaces change
+// again. WatchAndServeInterfaces returns when ctx is done, when the server is
+// shut down, or when watching interfaces fails, after the interfaces have
+// stopped being served. The connections are closed when it returns.
+func (s *Server) WatchAndServeInterfaces(ctx context.Context, f InterfaceFilter) error {
+	w, err := WatchInterfaces()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	results := make(chan interfaceServerResult)
+	serving := make(map[int]*interfaceServer)
+	running := 0
+
+	start := func(ifi net.Interface) {
+		c, err := ListenInterface(&ifi)
+		if err != nil {
+			s.logger().Warn("failed to listen on interface", "event", "error", "iface", ifi.Name, "err", err)
+			return
+		}
+
+		ictx, cancel := context.WithCancel(ctx)
+		is := &interfaceServer{index: ifi.Index, name: ifi.Name, conn: c, cancel: cancel}
+		serving[ifi.Index] = is
+		running++
+		go func() {
+			results <- interfaceServerResult{is: is, err: s.Serve(ictx, c)}
+		}()
+	}
+
+	update := func() error {
+		ifis, err := matchInterfaces(f)
+		if err != nil {
+			return err
+		}
+
+		up := make(map[int]bool, len(ifis))
+		for _, ifi := range ifis {
+			up[ifi.Index] = true
+			if serving[ifi.Index] == nil {
+				start(ifi)
+			}
+		}
+
+		for index, is := range serving {
+			if !up[index] {
+				is.cancel()
+				delete(serving, index)
+			}
+		}
+		return nil
+	}
+
+	err = update()
+	for err == nil {
+		select {
+		case _, ok := <-w.Changes():
+			if !ok {
+				err = errors.New("dhcp4: stopped watching interfaces")
+				break
+			}
+			err = update()
+
+		case r := <-results:
+			running--
+			r.is.conn.Close()
+			if serving[r.is.index] == r.is {
+				delete(serving, r.is.index)
+			}
+
+			switch {
+			case r.err == ErrServerClosed:
+				err = r.err
+			case errors.Is(r.err, context.Canceled) && ctx.Err() == nil:
+				// Stopped by update
+			case ctx.Err() != nil:
+				err = ctx.Err()
+			default:
+				s.logger().Warn("stopped serving interface", "event", "error", "iface", r.is.name, "err", r.err)
+			}
+
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+
+	for _, is := range serving {
+		is.cancel()
+	}
+	for ; running > 0; running-- {
+		r := <-results
+		r.is.conn.Close()
+	}
+	return err
+}
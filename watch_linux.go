@@ -0,0 +1,69 @@
+package dhcp4
+
+import (
+	"errors"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// netlinkConn is a netlink socket subscribed to changes of links and IPv4
+// addresses.
+type netlinkConn struct {
+	f   *os.File
+	buf []byte
+}
+
+func listenNetlink() (*netlinkConn, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC|unix.SOCK_NONBLOCK, unix.NETLINK_ROUTE)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+
+	sa := &unix.SockaddrNetlink{
+		Family: unix.AF_NETLINK,
+		Groups: unix.RTMGRP_LINK | unix.RTMGRP_IPV4_IFADDR,
+	}
+	if err := unix.Bind(fd, sa); err != nil {
+		unix.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+
+	// A non-blocking file uses the runtime poller, so that Close interrupts
+	// a pending Read
+	return &netlinkConn{
+		f:   os.NewFile(uintptr(fd), "netlink"),
+		buf: make([]byte, 32*1024),
+	}, nil
+}
+
+// readChange reads a batch of netlink messages, and returns whether any of
+// them reports a change of an interface or of an address.
+func (c *netlinkConn) readChange() (bool, error) {
+	n, err := c.f.Read(c.buf)
+	if errors.Is(err, unix.ENOBUFS) {
+		// The socket overflowed, so changes were lost
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	msgs, err := syscall.ParseNetlinkMessage(c.buf[:n])
+	if err != nil {
+		return true, nil
+	}
+
+	for _, m := range msgs {
+		switch m.Header.Type {
+		case unix.RTM_NEWLINK, unix.RTM_DELLINK, unix.RTM_NEWADDR, unix.RTM_DELADDR:
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (c *netlinkConn) Close() error {
+	return c.f.Close()
+}
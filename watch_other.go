@@ -0,0 +1,17 @@
+//go:build !linux
+
+package dhcp4
+
+type netlinkConn struct{}
+
+func listenNetlink() (*netlinkConn, error) {
+	return nil, errWatchUnsupported
+}
+
+func (c *netlinkConn) readChange() (bool, error) {
+	return false, errWatchUnsupported
+}
+
+func (c *netlinkConn) Close() error {
+	return nil
+}
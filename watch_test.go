@@ -0,0 +1,92 @@
+package dhcp4
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInterfaceTableWatched(t *testing.T) {
+	table := &interfaceTable{}
+	setFake := func() {
+		table.current.Store(&interfaceSnapshot{
+			ifaces:  map[int]hostInterface{1000: {name: "fake0"}},
+			updated: time.Now().Add(-time.Hour),
+			gen:     table.gen.Load(),
+		})
+	}
+
+	// Watched interfaces are not listed again until they change
+	table.watch(1)
+	setFake()
+	_, ok := table.lookup(1000)
+	assert.True(t, ok)
+
+	table.invalidate()
+	_, ok = table.lookup(1000)
+	assert.False(t, ok)
+
+	// Interfaces that aren't watched expire
+	table.watch(-1)
+	setFake()
+	_, ok = table.lookup(1000)
+	assert.False(t, ok)
+}
+
+func TestInterfaceTableRefreshing(t *testing.T) {
+	table := &interfaceTable{}
+	table.current.Store(&interfaceSnapshot{
+		ifaces: map[int]hostInterface{1000: {name: "fake0"}},
+	})
+
+	// Lookups use the expired table while it is being listed again
+	table.refreshing.Lock()
+	_, ok := table.lookup(1000)
+	assert.True(t, ok)
+	table.refreshing.Unlock()
+
+	_, ok = table.lookup(1000)
+	assert.False(t, ok)
+}
+
+func TestWatchInterfaces(t *testing.T) {
+	w, err := WatchInterfaces()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	assert.Equal(t, int32(1), hostInterfaces.watchers.Load())
+
+	assert.NoError(t, w.Close())
+	assert.NoError(t, w.Close())
+
+	// The channel is closed when the watcher stops
+	for range w.Changes() {
+	}
+
+	assert.Equal(t, int32(0), hostInterfaces.watchers.Load())
+}
+
+func TestWatchAndServeInterfaces(t *testing.T) {
+	s := Server{Handler: HandlerFunc(func(w ReplyWriter, p *Packet) {})}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error)
+	go func() {
+		errc <- s.WatchAndServeInterfaces(ctx, InterfaceFilter{Allow: []string{"nonexistent*"}})
+	}()
+
+	select {
+	case err := <-errc:
+		// Not supported on this platform
+		assert.Equal(t, errWatchUnsupported, err)
+		cancel()
+		return
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancel()
+	assert.Equal(t, context.Canceled, <-errc)
+}